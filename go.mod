@@ -1,7 +1,9 @@
 module github.com/johannesscr/micro
 
-go 1.17
+go 1.18
 
 require github.com/google/uuid v1.3.0
 
 require github.com/dottics/dutil v0.0.0-20211102062956-544d4946a1a4
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -1,6 +1,7 @@
 package microservice
 
 import (
+	"context"
 	"github.com/johannesscr/micro/msp"
 	"log"
 	"net/url"
@@ -32,9 +33,6 @@ func NewService() *Service {
 
 // GetUser returns a user from the Micro-Service
 func (s *Service) GetUser(uUUID string) (User, map[string][]string) {
-	// set the path
-	s.URL.Path = "/user/-"
-
 	// set the query parameters
 	q := url.Values{}
 	q.Add("uuid", uUUID)
@@ -46,7 +44,7 @@ func (s *Service) GetUser(uUUID string) (User, map[string][]string) {
 		Errors   map[string][]string `json:"errors"`
 	}{}
 
-	res, e := s.DoRequest("GET", s.URL, q, nil, nil)
+	res, e := s.DoRoute(context.Background(), "GET", "/user/-", nil, q, nil, nil)
 	if e != nil {
 		log.Println(e)
 	}
@@ -1,11 +1,15 @@
 package microservice
 
 import (
+	"embed"
 	"github.com/google/uuid"
 	"github.com/johannesscr/micro/microtest"
 	"testing"
 )
 
+//go:embed testdata
+var fixtures embed.FS
+
 func TestNewService(t *testing.T) {
 	s := NewService()
 	if s == nil {
@@ -95,25 +99,10 @@ func TestService_GetUser(t *testing.T) {
 	ms := microtest.MockServer(s)
 	defer ms.Server.Close() // defer shut down the microservice
 
-	e := &microtest.Exchange{
-		Response: microtest.Response{
-			Status: 200,
-			Header: map[string][]string{"x-token": {"124"}},
-			Body: `{
-				"message": "user found successfully", 
-				"data": {
-					"user": {
-						"uuid": "6a67f46e-d9de-4d63-8283-bf5a5aa1e582", 
-						"first_name": "james", 
-						"last_name": "bond", 
-						"email": "007@mi6.co.uk"
-					}
-				}, 
-				"errors": {}
-			}`,
-		},
+	err := ms.Load(fixtures, "testdata/get_user.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
-	ms.Append(e)
 
 	u, errors := s.GetUser(uuid.New().String())
 	if errors != nil {
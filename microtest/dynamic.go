@@ -0,0 +1,124 @@
+package microtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// TemplateData is the data of the request available to the Template of a
+// response.
+type TemplateData struct {
+	Method string
+	Path   string
+	// Params are the path params of the Route of the Matcher of the
+	// exchange and the named groups of its PathRegexp.
+	Params map[string]string
+	Query  url.Values
+	Header http.Header
+	// Body is the decoded JSON body, it is nil if the body is not JSON.
+	Body    interface{}
+	RawBody string
+}
+
+// templateFuncs are the functions available to the templates in addition
+// to the predefined functions of text/template.
+var templateFuncs = template.FuncMap{
+	// json marshals the value to JSON, e.g. {{json .Body}}
+	"json": func(v interface{}) (string, error) {
+		xb, err := json.Marshal(v)
+		return string(xb), err
+	},
+}
+
+// dynamic produces the response of the exchange to the request, with the
+// Respond function of the exchange iff it is set, and renders the Template
+// of the response iff it is set.
+func dynamic(e *Exchange, res Response, rr RecordedRequest) (Response, error) {
+	var m Matcher
+	if e != nil {
+		m = e.Match
+		if e.Respond != nil {
+			res = e.Respond(rr)
+		}
+	}
+	if res.Template == "" {
+		return res, nil
+	}
+
+	t, err := template.New("response").Funcs(templateFuncs).Parse(res.Template)
+	if err != nil {
+		return Response{}, NewErr("template", []string{err.Error()})
+	}
+	URL := rr.URL()
+	data := TemplateData{
+		Method:  rr.Method(),
+		Path:    URL.Path,
+		Params:  m.params(URL),
+		Query:   URL.Query(),
+		Header:  rr.Header(),
+		Body:    rr.JSON(),
+		RawBody: string(rr.Body()),
+	}
+	buf := &bytes.Buffer{}
+	err = t.Execute(buf, data)
+	if err != nil {
+		return Response{}, NewErr("template", []string{err.Error()})
+	}
+	res.Body = buf.String()
+	return res, nil
+}
+
+// params returns the path params of the URL for the Route and the named
+// groups of the PathRegexp of the matcher.
+func (m Matcher) params(URL url.URL) map[string]string {
+	params := make(map[string]string)
+	if m.PathRegexp != nil {
+		match := m.PathRegexp.FindStringSubmatch(URL.Path)
+		for i, name := range m.PathRegexp.SubexpNames() {
+			if i > 0 && name != "" && i < len(match) {
+				params[name] = match[i]
+			}
+		}
+	}
+	if m.Route != "" {
+		if ps, ok := matchRoute(m.Route, URL); ok {
+			for name, value := range ps {
+				params[name] = value
+			}
+		}
+	}
+	return params
+}
+
+// matchRoute matches the path of the URL to the route, e.g.
+// "/users/{uuid}", and returns the path params. A placeholder matches a
+// single non-empty path segment.
+func matchRoute(route string, URL url.URL) (map[string]string, bool) {
+	rs := strings.Split(strings.Trim(route, "/"), "/")
+	ps := strings.Split(strings.Trim(URL.EscapedPath(), "/"), "/")
+	if len(rs) != len(ps) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, r := range rs {
+		p, err := url.PathUnescape(ps[i])
+		if err != nil {
+			return nil, false
+		}
+		if strings.HasPrefix(r, "{") && strings.HasSuffix(r, "}") {
+			if p == "" {
+				return nil, false
+			}
+			params[r[1:len(r)-1]] = p
+			continue
+		}
+		if r != p {
+			return nil, false
+		}
+	}
+	return params, true
+}
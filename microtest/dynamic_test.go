@@ -0,0 +1,141 @@
+package microtest
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMock_dynamic(t *testing.T) {
+	tt := []struct {
+		name     string
+		exchange *Exchange
+		method   string
+		target   string
+		body     string
+		status   int
+		E        string
+	}{
+		{
+			name: "respond",
+			exchange: &Exchange{
+				Match: Matcher{Method: "POST", Path: "/users"},
+				Respond: func(r RecordedRequest) Response {
+					user := map[string]interface{}{}
+					_ = r.Decode(&user)
+					user["uuid"] = "a1"
+					return EnvelopeResponse(201, "user created", map[string]interface{}{"user": user}, nil)
+				},
+			},
+			method: "POST",
+			target: "/users",
+			body:   `{"name":"jane"}`,
+			status: 201,
+			E:      `{"message":"user created","data":{"user":{"name":"jane","uuid":"a1"}},"errors":{}}`,
+		},
+		{
+			name: "template",
+			exchange: &Exchange{
+				Match: Matcher{Route: "/users/{uuid}"},
+				Response: Response{
+					Status:   200,
+					Template: `{"uuid":"{{.Params.uuid}}","name":"{{.Body.name}}","q":"{{.Query.Get "q"}}","token":"{{.Header.Get "X-User-Token"}}","echo":{{json .Body}},"path":"{{.Method}} {{.Path}}"}`,
+				},
+			},
+			method: "PUT",
+			target: "/users/a%2F1?q=x",
+			body:   `{"name":"jane"}`,
+			status: 200,
+			E:      `{"uuid":"a/1","name":"jane","q":"x","token":"token","echo":{"name":"jane"},"path":"PUT /users/a/1"}`,
+		},
+		{
+			name: "template with regexp params",
+			exchange: &Exchange{
+				Match: Matcher{PathRegexp: regexp.MustCompile(`^/orders/(?P<id>\d+)$`)},
+				Response: Response{
+					Status:   200,
+					Template: `{"id":{{.Params.id}},"body":"{{.RawBody}}"}`,
+				},
+			},
+			method: "GET",
+			target: "/orders/7",
+			status: 200,
+			E:      `{"id":7,"body":""}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			mx := &Mock{}
+			ms := MockServerT(t, mx)
+			ms.Append(tc.exchange)
+
+			req, _ := http.NewRequest(tc.method, mx.URL.String()+tc.target, strings.NewReader(tc.body))
+			req.Header.Set("X-User-Token", "token")
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			xb, _ := io.ReadAll(res.Body)
+			_ = res.Body.Close()
+			if res.StatusCode != tc.status {
+				t.Errorf("expected %d got %d", tc.status, res.StatusCode)
+			}
+			if string(xb) != tc.E {
+				t.Errorf("expected '%v' got '%v'", tc.E, string(xb))
+			}
+		})
+	}
+}
+
+func TestMock_dynamic_error(t *testing.T) {
+	rt := &recorder{TB: t}
+	mx := &Mock{}
+	ms := MockServer(mx)
+	defer ms.Server.Close()
+	ms.TB = rt
+	ms.Append(&Exchange{Response: Response{Status: 200, Template: `{{.Missing}}`}})
+
+	res, err := http.Get(mx.URL.String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != 500 {
+		t.Errorf("expected %d got %d", 500, res.StatusCode)
+	}
+	if len(rt.errors) != 1 || !strings.Contains(rt.errors[0], "template") {
+		t.Errorf("expected a template error got '%v'", rt.errors)
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	tt := []struct {
+		route  string
+		path   string
+		ok     bool
+		params map[string]string
+	}{
+		{route: "/users/{uuid}", path: "/users/a1", ok: true, params: map[string]string{"uuid": "a1"}},
+		{route: "/users/{uuid}/orders/{id}", path: "/users/a1/orders/7", ok: true, params: map[string]string{"uuid": "a1", "id": "7"}},
+		{route: "/users/{uuid}", path: "/users/a%2F1", ok: true, params: map[string]string{"uuid": "a/1"}},
+		{route: "/users/{uuid}", path: "/users/a1/orders", ok: false},
+		{route: "/users/{uuid}", path: "/orders/a1", ok: false},
+		{route: "/users/{uuid}", path: "/users/", ok: false},
+	}
+	for _, tc := range tt {
+		URL, _ := url.Parse(tc.path)
+		params, ok := matchRoute(tc.route, *URL)
+		if ok != tc.ok {
+			t.Errorf("%s %s: expected '%v' got '%v'", tc.route, tc.path, tc.ok, ok)
+		}
+		for name, value := range tc.params {
+			if params[name] != value {
+				t.Errorf("%s %s: expected '%v' got '%v'", tc.route, tc.path, value, params[name])
+			}
+		}
+	}
+}
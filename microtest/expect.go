@@ -0,0 +1,142 @@
+package microtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Expectation declares the requests an Exchange is expected to receive,
+// the unmet expectations are reported by Mock.Verify. Each field that is
+// set is asserted.
+type Expectation struct {
+	Method string
+	Path   string
+	// Query are the query params the requests must have, the requests may
+	// have other query params.
+	Query url.Values
+	// Header are the headers the requests must have, the requests may have
+	// other headers.
+	Header http.Header
+	// Body is the expected body of the requests, when both bodies are JSON
+	// they are compared as JSON documents.
+	Body string
+	// Times is the number of requests the exchange is expected to receive,
+	// default 1, Never expects no requests and Forever any number of
+	// requests. The exchange responds to the same number of requests
+	// unless its Times is set.
+	Times int
+}
+
+// Never is the Expectation.Times of an exchange that is expected to receive
+// no requests.
+const Never = -2
+
+// MockServerT is the same as MockServer, however, a request that no
+// exchange responds to fails the test, and the mock server is closed and
+// the expectations of the exchanges are verified when the test completes.
+func MockServerT(t testing.TB, mx mock) *Mock {
+	t.Helper()
+	m := MockServer(mx)
+	m.TB = t
+	t.Cleanup(func() {
+		m.Server.Close()
+		m.Verify(t)
+	})
+	return m
+}
+
+// Verify reports the unmet expectations of the exchanges and the requests
+// that did not match any exchange as errors of the test.
+func (m *Mock) Verify(t testing.TB) {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, e := range m.Exchanges {
+		if e.Expect == nil {
+			continue
+		}
+		for _, problem := range e.Expect.verify(e.Requests()) {
+			t.Errorf("exchange %d: %s", i, problem)
+		}
+	}
+	for _, r := range m.unexpected {
+		URL := r.URL()
+		t.Errorf("unexpected request: %s %s", r.Method(), URL.String())
+	}
+}
+
+// verify returns the unmet expectations of the received requests.
+func (x *Expectation) verify(requests []RecordedRequest) []string {
+	var problems []string
+	times := x.Times
+	switch {
+	case times == Never:
+		times = 0
+	case times <= 0 && times != Forever:
+		times = 1
+	}
+	if times != Forever && len(requests) != times {
+		problems = append(problems, fmt.Sprintf("expected %d request(s) got %d", times, len(requests)))
+	}
+	for i, r := range requests {
+		for _, p := range x.diff(r) {
+			problems = append(problems, fmt.Sprintf("request %d: %s", i, p))
+		}
+	}
+	return problems
+}
+
+// diff returns the differences between the expectation and the request.
+func (x *Expectation) diff(r RecordedRequest) []string {
+	var diffs []string
+	if x.Method != "" && !strings.EqualFold(x.Method, r.method) {
+		diffs = append(diffs, fmt.Sprintf("expected method '%v' got '%v'", x.Method, r.method))
+	}
+	if x.Path != "" && x.Path != r.url.Path {
+		diffs = append(diffs, fmt.Sprintf("expected path '%v' got '%v'", x.Path, r.url.Path))
+	}
+	query := r.url.Query()
+	for _, key := range sortedKeys(x.Query) {
+		if !contains(query[key], x.Query[key]) {
+			diffs = append(diffs, fmt.Sprintf("expected query '%v' to be '%v' got '%v'", key, x.Query[key], query[key]))
+		}
+	}
+	for _, key := range sortedKeys(x.Header) {
+		values := r.header.Values(key)
+		if !contains(values, x.Header[key]) {
+			diffs = append(diffs, fmt.Sprintf("expected header '%v' to be '%v' got '%v'", http.CanonicalHeaderKey(key), x.Header[key], values))
+		}
+	}
+	if x.Body != "" && !equalBody([]byte(x.Body), r.body) {
+		diffs = append(diffs, fmt.Sprintf("expected body\n\t%s\ngot\n\t%s", x.Body, string(r.body)))
+	}
+	return diffs
+}
+
+// equalBody reports whether the bodies are equal, JSON bodies are equal
+// when the JSON documents are equal.
+func equalBody(want []byte, have []byte) bool {
+	var w, h interface{}
+	if json.Unmarshal(want, &w) == nil && json.Unmarshal(have, &h) == nil {
+		xw, _ := json.Marshal(w)
+		xh, _ := json.Marshal(h)
+		return bytes.Equal(xw, xh)
+	}
+	return bytes.Equal(want, have)
+}
+
+// sortedKeys returns the sorted keys of the map.
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
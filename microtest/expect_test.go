@@ -0,0 +1,173 @@
+package microtest
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// recorder records the errors reported to the test.
+type recorder struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Cleanup(f func()) {
+	r.cleanups = append(r.cleanups, f)
+}
+
+func TestMock_Verify(t *testing.T) {
+	expect := &Expectation{
+		Method: "POST",
+		Path:   "/users",
+		Query:  url.Values{"notify": {"true"}},
+		Header: http.Header{"X-User-Token": {"token"}},
+		Body:   `{"name":"jane","age":30}`,
+	}
+	tt := []struct {
+		name   string
+		do     func(URL string)
+		errors []string
+	}{
+		{
+			name: "met",
+			do: func(URL string) {
+				req, _ := http.NewRequest("POST", URL+"/users?notify=true", strings.NewReader(`{"age":30, "name":"jane"}`))
+				req.Header.Set("X-User-Token", "token")
+				_, _ = http.DefaultClient.Do(req)
+			},
+		},
+		{
+			name: "not received",
+			do:   func(string) {},
+			errors: []string{
+				"exchange 0: expected 1 request(s) got 0",
+			},
+		},
+		{
+			name: "unmet",
+			do: func(URL string) {
+				_, _ = http.Post(URL+"/user?notify=false", "application/json", strings.NewReader(`{"name":"john","age":30}`))
+			},
+			errors: []string{
+				"exchange 0: request 0: expected path '/users' got '/user'",
+				"exchange 0: request 0: expected query 'notify' to be '[true]' got '[false]'",
+				"exchange 0: request 0: expected header 'X-User-Token' to be '[token]' got '[]'",
+				"exchange 0: request 0: expected body\n\t{\"name\":\"jane\",\"age\":30}\ngot\n\t{\"name\":\"john\",\"age\":30}",
+			},
+		},
+		{
+			name: "unexpected",
+			do: func(URL string) {
+				req, _ := http.NewRequest("POST", URL+"/users?notify=true", strings.NewReader(`{"name":"jane","age":30}`))
+				req.Header.Set("X-User-Token", "token")
+				_, _ = http.DefaultClient.Do(req)
+				_, _ = http.Post(URL+"/orders", "application/json", strings.NewReader(`{}`))
+			},
+			errors: []string{
+				"microtest: POST /orders: map[transmission:[exceeded mock request/response exchange transmissions]]",
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rt := &recorder{TB: t}
+			mx := &Mock{}
+			m := MockServerT(rt, mx)
+			m.Append(&Exchange{Expect: expect, Response: Response{Status: 201}})
+			tc.do(mx.URL.String())
+
+			if len(rt.cleanups) != 1 {
+				t.Fatalf("expected 1 cleanup got %d", len(rt.cleanups))
+			}
+			rt.cleanups[0]()
+			if strings.Join(rt.errors, "\n") != strings.Join(tc.errors, "\n") {
+				t.Errorf("expected '%v' got '%v'", tc.errors, rt.errors)
+			}
+		})
+	}
+}
+
+func TestMock_Verify_unexpected(t *testing.T) {
+	rt := &recorder{TB: t}
+	mx := &Mock{}
+	ms := MockServer(mx)
+	defer ms.Server.Close()
+
+	res, err := http.Post(mx.URL.String()+"/orders", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != 500 {
+		t.Errorf("expected %d got %d", 500, res.StatusCode)
+	}
+	ms.Verify(rt)
+	if len(rt.errors) != 1 || rt.errors[0] != "unexpected request: POST /orders" {
+		t.Errorf("expected '%v' got '%v'", "unexpected request: POST /orders", rt.errors)
+	}
+}
+
+func TestExpectation_Times(t *testing.T) {
+	tt := []struct {
+		name     string
+		times    int
+		requests int
+		errors   []string
+	}{
+		{name: "default", requests: 1},
+		{name: "fewer", times: 2, requests: 1, errors: []string{"exchange 0: expected 2 request(s) got 1"}},
+		{name: "more", times: 2, requests: 3, errors: []string{"exchange 0: expected 2 request(s) got 3"}},
+		{name: "never", times: Never},
+		{name: "never called", times: Never, requests: 1, errors: []string{"exchange 0: expected 0 request(s) got 1"}},
+		{name: "forever", times: Forever, requests: 3},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rt := &recorder{TB: t}
+			m := &Mock{}
+			m.Append(&Exchange{Expect: &Expectation{Method: "GET", Times: tc.times}})
+			for i := 0; i < tc.requests; i++ {
+				m.Exchanges[0].received = append(m.Exchanges[0].received, record(NewRequest("GET", "/", nil, nil, nil), nil))
+			}
+			m.Verify(rt)
+			if strings.Join(rt.errors, "\n") != strings.Join(tc.errors, "\n") {
+				t.Errorf("expected '%v' got '%v'", tc.errors, rt.errors)
+			}
+		})
+	}
+}
+
+func TestExpectation_Times_responds(t *testing.T) {
+	// the exchange responds to the number of requests it is expected to
+	// receive
+	rt := &recorder{TB: t}
+	mx := &Mock{}
+	m := MockServerT(rt, mx)
+	m.Append(&Exchange{Expect: &Expectation{Method: "GET", Times: 3}, Response: Response{Status: 200}})
+	for i := 0; i < 3; i++ {
+		res, err := http.Get(mx.URL.String())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_ = res.Body.Close()
+		if res.StatusCode != 200 {
+			t.Errorf("expected %d got %d", 200, res.StatusCode)
+		}
+	}
+	rt.cleanups[0]()
+	if len(rt.errors) != 0 {
+		t.Errorf("expected no errors got '%v'", rt.errors)
+	}
+}
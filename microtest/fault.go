@@ -0,0 +1,107 @@
+package microtest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Fault is a failure injected into the response of an exchange.
+type Fault string
+
+const (
+	// FaultHang holds the request without responding until the client
+	// gives up. The client must have a timeout, otherwise closing the mock
+	// server blocks.
+	FaultHang Fault = "hang"
+	// FaultDropBody responds with the status, the headers and half of the
+	// body, then closes the connection.
+	FaultDropBody Fault = "dropBody"
+	// FaultTruncatedJSON responds with the first half of the body as the
+	// complete body.
+	FaultTruncatedJSON Fault = "truncatedJSON"
+	// FaultMalformedJSON responds with a body that is not valid JSON.
+	FaultMalformedJSON Fault = "malformedJSON"
+	// FaultReset resets the connection without responding.
+	FaultReset Fault = "reset"
+	// FaultEmptyReply closes the connection without responding.
+	FaultEmptyReply Fault = "emptyReply"
+)
+
+// respond writes the response, after the delay of the response, and
+// injects the fault of the response.
+func respond(w http.ResponseWriter, r *http.Request, res Response) error {
+	if res.Delay > 0 {
+		select {
+		case <-time.After(res.Delay):
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+
+	body := []byte(res.Body)
+	switch res.Fault {
+	case FaultHang:
+		<-r.Context().Done()
+		return nil
+	case FaultDropBody:
+		return hijack(w, func(conn net.Conn, rw *bufio.ReadWriter) error {
+			_, _ = fmt.Fprintf(rw, "HTTP/1.1 %d %s\r\n", res.Status, http.StatusText(res.Status))
+			header := res.Header.Clone()
+			if header == nil {
+				header = make(http.Header)
+			}
+			for _, cookie := range res.Cookies {
+				header.Add("Set-Cookie", cookie.String())
+			}
+			header.Set("Content-Length", strconv.Itoa(len(body)))
+			_ = header.Write(rw)
+			_, _ = rw.WriteString("\r\n")
+			_, _ = rw.Write(body[:len(body)/2])
+			return rw.Flush()
+		})
+	case FaultReset:
+		return hijack(w, func(conn net.Conn, _ *bufio.ReadWriter) error {
+			if tc, ok := conn.(*tls.Conn); ok {
+				conn = tc.NetConn()
+			}
+			if tc, ok := conn.(*net.TCPConn); ok {
+				// discard the unsent data and send a RST on close
+				return tc.SetLinger(0)
+			}
+			return nil
+		})
+	case FaultEmptyReply:
+		return hijack(w, func(net.Conn, *bufio.ReadWriter) error {
+			return nil
+		})
+	case FaultTruncatedJSON:
+		body = body[:len(body)/2]
+	case FaultMalformedJSON:
+		// an object must start with a key, so the body is never valid
+		body = append([]byte("{"), body...)
+	}
+
+	return write(w, res, body)
+}
+
+// hijack takes over the connection of the response writer, calls f and
+// closes the connection.
+func hijack(w http.ResponseWriter, f func(net.Conn, *bufio.ReadWriter) error) error {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return NewErr("fault", []string{"the response writer cannot be hijacked"})
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return NewErr("fault", []string{err.Error()})
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	return f(conn, rw)
+}
@@ -0,0 +1,78 @@
+package microtest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRespond_fault(t *testing.T) {
+	body := `{"message":"ok","data":{"user":"jane"},"errors":{}}`
+	type E struct {
+		err     bool
+		readErr bool
+		body    string
+		valid   bool
+	}
+	tt := []struct {
+		name     string
+		response Response
+		E        E
+	}{
+		{name: "none", response: Response{Status: 200, Body: body}, E: E{body: body, valid: true}},
+		{name: "delay", response: Response{Status: 200, Body: body, Delay: 50 * time.Millisecond}, E: E{body: body, valid: true}},
+		{name: "delay longer than the timeout", response: Response{Status: 200, Body: body, Delay: time.Second}, E: E{err: true}},
+		{name: "hang", response: Response{Status: 200, Body: body, Fault: FaultHang}, E: E{err: true}},
+		{name: "drop body", response: Response{Status: 200, Body: body, Fault: FaultDropBody}, E: E{readErr: true}},
+		{name: "truncated JSON", response: Response{Status: 200, Body: body, Fault: FaultTruncatedJSON}, E: E{body: body[:len(body)/2]}},
+		{name: "malformed JSON", response: Response{Status: 200, Body: body, Fault: FaultMalformedJSON}, E: E{body: "{" + body}},
+		{name: "reset", response: Response{Status: 200, Body: body, Fault: FaultReset}, E: E{err: true}},
+		{name: "empty reply", response: Response{Status: 200, Body: body, Fault: FaultEmptyReply}, E: E{err: true}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			mx := &Mock{}
+			ms := MockServer(mx)
+			defer ms.Server.Close()
+			ms.Append(&Exchange{Response: tc.response})
+
+			c := http.Client{Timeout: 200 * time.Millisecond}
+			start := time.Now()
+			res, err := c.Post(mx.URL.String(), "application/json", strings.NewReader(`{}`))
+			if tc.E.err {
+				if err == nil {
+					_ = res.Body.Close()
+					t.Errorf("expected an error got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			xb, err := io.ReadAll(res.Body)
+			_ = res.Body.Close()
+			if tc.E.readErr {
+				if err == nil {
+					t.Errorf("expected an error got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(xb) != tc.E.body {
+				t.Errorf("expected '%v' got '%v'", tc.E.body, string(xb))
+			}
+			if json.Valid(xb) != tc.E.valid {
+				t.Errorf("expected valid JSON '%v' got '%v'", tc.E.valid, json.Valid(xb))
+			}
+			if elapsed := time.Since(start); elapsed < tc.response.Delay {
+				t.Errorf("expected a delay of at least %v got %v", tc.response.Delay, elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package microtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fixture is a file of exchanges.
+//
+//	exchanges:
+//	  - match:
+//	      method: GET
+//	      path: /user/-
+//	      query: {uuid: ["6a67f46e-d9de-4d63-8283-bf5a5aa1e582"]}
+//	    response:
+//	      status: 200
+//	      header: {Content-Type: [application/json]}
+//	      bodyFile: bodies/user.json
+//	    times: 1
+type fixture struct {
+	Exchanges []fixtureExchange `json:"exchanges" yaml:"exchanges"`
+}
+
+// fixtureExchange is an exchange of a fixture.
+type fixtureExchange struct {
+	Match    fixtureMatch    `json:"match" yaml:"match"`
+	Response fixtureResponse `json:"response" yaml:"response"`
+	Times    int             `json:"times" yaml:"times"`
+}
+
+// fixtureMatch is the matcher of an exchange of a fixture.
+type fixtureMatch struct {
+	Method     string              `json:"method" yaml:"method"`
+	Path       string              `json:"path" yaml:"path"`
+	PathPrefix string              `json:"pathPrefix" yaml:"pathPrefix"`
+	PathRegexp string              `json:"pathRegexp" yaml:"pathRegexp"`
+	Route      string              `json:"route" yaml:"route"`
+	Query      map[string][]string `json:"query" yaml:"query"`
+	Header     map[string][]string `json:"header" yaml:"header"`
+	// JSON is either a JSON string or a document.
+	JSON interface{} `json:"json" yaml:"json"`
+}
+
+// fixtureResponse is the response of an exchange of a fixture.
+type fixtureResponse struct {
+	Status int                 `json:"status" yaml:"status"`
+	Header map[string][]string `json:"header" yaml:"header"`
+	// Body is either a string or a document that is marshalled to JSON.
+	Body interface{} `json:"body" yaml:"body"`
+	// BodyFile is the file of the body, relative to the fixture.
+	BodyFile string `json:"bodyFile" yaml:"bodyFile"`
+	// Template is a text/template of the body.
+	Template string              `json:"template" yaml:"template"`
+	Cookies  []fixtureCookie     `json:"cookies" yaml:"cookies"`
+	Trailer  map[string][]string `json:"trailer" yaml:"trailer"`
+	Chunked  bool                `json:"chunked" yaml:"chunked"`
+	// Delay is a duration, e.g. "100ms".
+	Delay string `json:"delay" yaml:"delay"`
+	Fault Fault  `json:"fault" yaml:"fault"`
+}
+
+// fixtureCookie is a cookie of the response of an exchange of a fixture.
+type fixtureCookie struct {
+	Name     string `json:"name" yaml:"name"`
+	Value    string `json:"value" yaml:"value"`
+	Path     string `json:"path" yaml:"path"`
+	Domain   string `json:"domain" yaml:"domain"`
+	MaxAge   int    `json:"maxAge" yaml:"maxAge"`
+	Secure   bool   `json:"secure" yaml:"secure"`
+	HttpOnly bool   `json:"httpOnly" yaml:"httpOnly"`
+}
+
+// LoadFixtures loads the exchanges from the JSON or YAML fixture files in
+// the file system that match the patterns, see fs.Glob. The files are
+// loaded in lexical order. A response body either is inline or references
+// a file, relative to the fixture file, with bodyFile. Use os.DirFS to
+// load fixtures from a directory or an embed.FS to embed the fixtures.
+func LoadFixtures(fsys fs.FS, patterns ...string) ([]*Exchange, error) {
+	var names []string
+	for _, pattern := range patterns {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, NewErr("fixture", []string{err.Error()})
+		}
+		if len(matches) == 0 {
+			return nil, NewErr("fixture", []string{fmt.Sprintf("no fixtures match %s", pattern)})
+		}
+		names = append(names, matches...)
+	}
+	sort.Strings(names)
+
+	var exchanges []*Exchange
+	for _, name := range names {
+		xs, err := loadFixture(fsys, name)
+		if err != nil {
+			return nil, NewErr("fixture", []string{fmt.Sprintf("%s: %v", name, err)})
+		}
+		exchanges = append(exchanges, xs...)
+	}
+	return exchanges, nil
+}
+
+// Load appends the exchanges loaded from the fixture files, see
+// LoadFixtures.
+func (m *Mock) Load(fsys fs.FS, patterns ...string) error {
+	exchanges, err := LoadFixtures(fsys, patterns...)
+	if err != nil {
+		return err
+	}
+	for _, e := range exchanges {
+		m.Append(e)
+	}
+	return nil
+}
+
+// loadFixture loads the exchanges of a fixture file.
+func loadFixture(fsys fs.FS, name string) ([]*Exchange, error) {
+	xb, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	f := fixture{}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json":
+		err = json.Unmarshal(xb, &f)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(xb, &f)
+	default:
+		err = fmt.Errorf("unknown fixture format %s", path.Ext(name))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	exchanges := make([]*Exchange, 0, len(f.Exchanges))
+	for i, fe := range f.Exchanges {
+		e, err := fe.exchange(fsys, path.Dir(name))
+		if err != nil {
+			return nil, fmt.Errorf("exchange %d: %w", i, err)
+		}
+		exchanges = append(exchanges, e)
+	}
+	return exchanges, nil
+}
+
+// exchange creates the Exchange of the fixture, the body files are read
+// relative to the directory of the fixture.
+func (fe fixtureExchange) exchange(fsys fs.FS, dir string) (*Exchange, error) {
+	e := &Exchange{
+		Match: Matcher{
+			Method:     fe.Match.Method,
+			Path:       fe.Match.Path,
+			PathPrefix: fe.Match.PathPrefix,
+			Route:      fe.Match.Route,
+		},
+		Response: Response{
+			Status:   fe.Response.Status,
+			Chunked:  fe.Response.Chunked,
+			Fault:    fe.Response.Fault,
+			Template: fe.Response.Template,
+		},
+		Times: fe.Times,
+	}
+	if e.Response.Status == 0 {
+		e.Response.Status = http.StatusOK
+	}
+	if fe.Match.PathRegexp != "" {
+		re, err := regexp.Compile(fe.Match.PathRegexp)
+		if err != nil {
+			return nil, err
+		}
+		e.Match.PathRegexp = re
+	}
+	if fe.Match.Query != nil {
+		e.Match.Query = url.Values(fe.Match.Query)
+	}
+	if fe.Match.Header != nil {
+		e.Match.Header = make(http.Header)
+		for key, values := range fe.Match.Header {
+			e.Match.Header[http.CanonicalHeaderKey(key)] = values
+		}
+	}
+	if fe.Match.JSON != nil {
+		s, err := document(fe.Match.JSON)
+		if err != nil {
+			return nil, err
+		}
+		e.Match.JSON = s
+	}
+
+	if fe.Response.Header != nil {
+		e.Response.Header = make(http.Header)
+		for key, values := range fe.Response.Header {
+			e.Response.Header[http.CanonicalHeaderKey(key)] = values
+		}
+	}
+	if fe.Response.Trailer != nil {
+		e.Response.Trailer = make(http.Header)
+		for key, values := range fe.Response.Trailer {
+			e.Response.Trailer[http.CanonicalHeaderKey(key)] = values
+		}
+	}
+	for _, c := range fe.Response.Cookies {
+		e.Response.Cookies = append(e.Response.Cookies, &http.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			MaxAge:   c.MaxAge,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+		})
+	}
+	if fe.Response.Delay != "" {
+		d, err := time.ParseDuration(fe.Response.Delay)
+		if err != nil {
+			return nil, err
+		}
+		e.Response.Delay = d
+	}
+	switch {
+	case fe.Response.BodyFile != "" && fe.Response.Body != nil:
+		return nil, fmt.Errorf("response has both a body and a bodyFile")
+	case fe.Response.BodyFile != "":
+		xb, err := fs.ReadFile(fsys, path.Join(dir, fe.Response.BodyFile))
+		if err != nil {
+			return nil, err
+		}
+		e.Response.Body = string(xb)
+	case fe.Response.Body != nil:
+		s, err := document(fe.Response.Body)
+		if err != nil {
+			return nil, err
+		}
+		e.Response.Body = s
+	}
+	return e, nil
+}
+
+// document returns the string as is or the document marshalled to JSON.
+func document(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	xb, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(xb), nil
+}
@@ -0,0 +1,116 @@
+package microtest
+
+import (
+	"embed"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+//go:embed testdata/fixtures
+var fixtures embed.FS
+
+func TestLoadFixtures(t *testing.T) {
+	exchanges, err := LoadFixtures(os.DirFS("testdata/fixtures"), "*.yaml", "*.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(exchanges) != 4 {
+		t.Fatalf("expected 4 got %d", len(exchanges))
+	}
+
+	// the files are loaded in lexical order
+	orders := exchanges[0]
+	if orders.Match.Method != "GET" || orders.Match.PathPrefix != "/orders" || orders.Match.Query.Get("status") != "open" {
+		t.Errorf("expected the orders matcher got '%+v'", orders.Match)
+	}
+	if orders.Response.Body != `{"message":"orders","data":{"orders":[]},"errors":{}}` {
+		t.Errorf("expected the inline body got '%v'", orders.Response.Body)
+	}
+	if len(orders.Response.Cookies) != 1 || orders.Response.Cookies[0].String() != "session=abc; Path=/; HttpOnly" {
+		t.Errorf("expected the session cookie got '%v'", orders.Response.Cookies)
+	}
+	if orders.Response.Trailer.Get("X-Checksum") != "a1b2" || !orders.Response.Chunked {
+		t.Errorf("expected the chunked response with a trailer got '%+v'", orders.Response)
+	}
+
+	user := exchanges[1]
+	if !user.Match.PathRegexp.MatchString("/users/a1") || user.Match.Header.Get("X-User-Token") != "token" {
+		t.Errorf("expected the user matcher got '%+v'", user.Match)
+	}
+	if user.Times != 2 || user.Response.Status != 200 || user.Response.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("expected the user response got '%+v'", user.Response)
+	}
+	if !strings.Contains(user.Response.Body, `"first_name": "james"`) {
+		t.Errorf("expected the body of the body file got '%v'", user.Response.Body)
+	}
+
+	create := exchanges[2]
+	if create.Match.JSON != `{"name":"jane"}` {
+		t.Errorf("expected '%v' got '%v'", `{"name":"jane"}`, create.Match.JSON)
+	}
+	E := `{"data":{},"errors":{},"message":"user created"}`
+	if create.Response.Body != E {
+		t.Errorf("expected '%v' got '%v'", E, create.Response.Body)
+	}
+	if create.Response.Delay != 10*time.Millisecond {
+		t.Errorf("expected '%v' got '%v'", 10*time.Millisecond, create.Response.Delay)
+	}
+
+	remove := exchanges[3]
+	if remove.Match.Route != "/users/{uuid}" || !strings.Contains(remove.Response.Template, "{{.Params.uuid}}") {
+		t.Errorf("expected the route and template got '%+v' '%+v'", remove.Match, remove.Response)
+	}
+}
+
+func TestLoadFixtures_error(t *testing.T) {
+	tt := []struct {
+		name    string
+		pattern string
+		E       string
+	}{
+		{name: "no match", pattern: "*.toml", E: "map[fixture:[no fixtures match *.toml]]"},
+		{name: "body and body file", pattern: "invalid.yaml", E: "map[fixture:[invalid.yaml: exchange 0: response has both a body and a bodyFile]]"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadFixtures(os.DirFS("testdata"), tc.pattern)
+			if err == nil || err.Error() != tc.E {
+				t.Errorf("expected '%v' got '%v'", tc.E, err)
+			}
+		})
+	}
+}
+
+func TestMock_Load(t *testing.T) {
+	mx := &Mock{}
+	ms := MockServer(mx)
+	defer ms.Server.Close()
+	if err := ms.Load(fixtures, "testdata/fixtures/*.yaml"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req, _ := http.NewRequest("GET", mx.URL.String()+"/users/a1", nil)
+	req.Header.Set("X-User-Token", "token")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	xb, _ := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if res.StatusCode != 200 || !strings.Contains(string(xb), "user found successfully") {
+		t.Errorf("expected the user got %d '%v'", res.StatusCode, string(xb))
+	}
+
+	res, err = http.Post(mx.URL.String()+"/users", "application/json", strings.NewReader(`{"name":"jane"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != 201 {
+		t.Errorf("expected %d got %d", 201, res.StatusCode)
+	}
+}
@@ -0,0 +1,149 @@
+package microtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Matcher declares the requests an Exchange responds to. The zero value
+// matches any request, each field that is set narrows the match.
+type Matcher struct {
+	// Method is the HTTP method of the request.
+	Method string
+	// Path is the exact path of the request.
+	Path string
+	// PathPrefix is the prefix of the path of the request.
+	PathPrefix string
+	// PathRegexp matches the path of the request, the named groups are path
+	// params of a response Template.
+	PathRegexp *regexp.Regexp
+	// Route matches the path of the request to a route template, e.g.
+	// "/users/{uuid}", the placeholders are path params of a response
+	// Template.
+	Route string
+	// Query are query params the request must have, the request may have
+	// other query params.
+	Query url.Values
+	// Header are headers the request must have, the request may have other
+	// headers.
+	Header http.Header
+	// JSON is a JSON document the body of the request must contain, objects
+	// match if the body has at least the members of the object, arrays and
+	// values must be equal.
+	JSON string
+}
+
+// match reports whether the request matches and the specificity of the
+// match, a more specific match is a better match.
+func (m Matcher) match(r *http.Request, body []byte) (bool, int) {
+	score := 0
+	if m.Method != "" {
+		if !strings.EqualFold(m.Method, r.Method) {
+			return false, 0
+		}
+		score++
+	}
+	if m.Path != "" {
+		if r.URL.Path != m.Path {
+			return false, 0
+		}
+		score += 3
+	}
+	if m.PathRegexp != nil {
+		if !m.PathRegexp.MatchString(r.URL.Path) {
+			return false, 0
+		}
+		score += 2
+	}
+	if m.Route != "" {
+		if r.URL == nil {
+			return false, 0
+		}
+		if _, ok := matchRoute(m.Route, *r.URL); !ok {
+			return false, 0
+		}
+		score += 2
+	}
+	if m.PathPrefix != "" {
+		if !strings.HasPrefix(r.URL.Path, m.PathPrefix) {
+			return false, 0
+		}
+		score++
+	}
+	for key, values := range m.Query {
+		if !contains(r.URL.Query()[key], values) {
+			return false, 0
+		}
+		score++
+	}
+	for key, values := range m.Header {
+		if !contains(r.Header.Values(key), values) {
+			return false, 0
+		}
+		score++
+	}
+	if m.JSON != "" {
+		var want, have interface{}
+		if json.Unmarshal([]byte(m.JSON), &want) != nil || json.Unmarshal(body, &have) != nil {
+			return false, 0
+		}
+		if !containsJSON(have, want) {
+			return false, 0
+		}
+		score++
+	}
+	return true, score
+}
+
+// contains reports whether all the values are in have.
+func contains(have []string, values []string) bool {
+	for _, v := range values {
+		found := false
+		for _, h := range have {
+			if h == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// containsJSON reports whether the decoded JSON document have contains the
+// decoded JSON document want.
+func containsJSON(have interface{}, want interface{}) bool {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		h, ok := have.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, wv := range w {
+			hv, ok := h[key]
+			if !ok || !containsJSON(hv, wv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		h, ok := have.([]interface{})
+		if !ok || len(h) != len(w) {
+			return false
+		}
+		for i := range w {
+			if !containsJSON(h[i], w[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(have, want)
+	}
+}
@@ -0,0 +1,139 @@
+package microtest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMatcher_match(t *testing.T) {
+	request := func() *http.Request {
+		r := httptest.NewRequest("POST", "/users/a1/orders?status=open&limit=10", nil)
+		r.Header.Set("X-User-Token", "token")
+		return r
+	}
+	body := []byte(`{"name":"order","items":[1,2],"meta":{"source":"web","id":3}}`)
+	type E struct {
+		ok    bool
+		score int
+	}
+	tt := []struct {
+		name    string
+		matcher Matcher
+		E       E
+	}{
+		{name: "any", matcher: Matcher{}, E: E{ok: true, score: 0}},
+		{name: "method", matcher: Matcher{Method: "post"}, E: E{ok: true, score: 1}},
+		{name: "method mismatch", matcher: Matcher{Method: "GET"}},
+		{name: "path", matcher: Matcher{Path: "/users/a1/orders"}, E: E{ok: true, score: 3}},
+		{name: "path mismatch", matcher: Matcher{Path: "/users/a1"}},
+		{name: "path prefix", matcher: Matcher{PathPrefix: "/users/"}, E: E{ok: true, score: 1}},
+		{name: "path prefix mismatch", matcher: Matcher{PathPrefix: "/orders/"}},
+		{name: "path regexp", matcher: Matcher{PathRegexp: regexp.MustCompile(`^/users/[^/]+/orders$`)}, E: E{ok: true, score: 2}},
+		{name: "path regexp mismatch", matcher: Matcher{PathRegexp: regexp.MustCompile(`^/users$`)}},
+		{name: "route", matcher: Matcher{Route: "/users/{uuid}/orders"}, E: E{ok: true, score: 2}},
+		{name: "route mismatch", matcher: Matcher{Route: "/users/{uuid}"}},
+		{name: "query", matcher: Matcher{Query: url.Values{"status": {"open"}}}, E: E{ok: true, score: 1}},
+		{name: "query mismatch", matcher: Matcher{Query: url.Values{"status": {"closed"}}}},
+		{name: "header", matcher: Matcher{Header: http.Header{"X-User-Token": {"token"}}}, E: E{ok: true, score: 1}},
+		{name: "header mismatch", matcher: Matcher{Header: http.Header{"X-Api-Key": {"key"}}}},
+		{name: "json", matcher: Matcher{JSON: `{"items":[1,2],"meta":{"source":"web"}}`}, E: E{ok: true, score: 1}},
+		{name: "json mismatch", matcher: Matcher{JSON: `{"items":[1]}`}},
+		{name: "json invalid", matcher: Matcher{JSON: `{`}},
+		{
+			name: "all",
+			matcher: Matcher{
+				Method: "POST",
+				Path:   "/users/a1/orders",
+				Query:  url.Values{"status": {"open"}, "limit": {"10"}},
+				JSON:   `{"name":"order"}`,
+			},
+			E: E{ok: true, score: 7},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ok, score := tc.matcher.match(request(), body)
+			if ok != tc.E.ok {
+				t.Errorf("expected '%v' got '%v'", tc.E.ok, ok)
+			}
+			if score != tc.E.score {
+				t.Errorf("expected %d got %d", tc.E.score, score)
+			}
+		})
+	}
+}
+
+func TestMock_transmit_match(t *testing.T) {
+	m := &Mock{}
+	catchAll := &Exchange{Response: Response{Status: 200, Body: "any"}}
+	users := &Exchange{Match: Matcher{Method: "GET", PathPrefix: "/users"}, Response: Response{Status: 200, Body: "users"}}
+	user := &Exchange{Match: Matcher{Method: "GET", Path: "/users/a1"}, Response: Response{Status: 200, Body: "user"}}
+	order := &Exchange{Match: Matcher{Method: "POST", JSON: `{"user":"a1"}`}, Response: Response{Status: 201, Body: "order"}}
+	m.Append(catchAll)
+	m.Append(users)
+	m.Append(user)
+	m.Append(order)
+
+	tt := []struct {
+		method string
+		target string
+		body   string
+		E      *Exchange
+	}{
+		{method: "POST", target: "/orders", body: `{"user":"a1","total":3}`, E: order},
+		{method: "GET", target: "/users/a1", E: user},
+		{method: "GET", target: "/users/a2", E: users},
+		{method: "GET", target: "/users/a1", E: catchAll},
+	}
+	for _, tc := range tt {
+		r := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+		res, err := m.transmit(r)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if res.Body != tc.E.Response.Body {
+			t.Errorf("%s %s: expected '%v' got '%v'", tc.method, tc.target, tc.E.Response.Body, res.Body)
+		}
+		if tc.E.Request != r {
+			t.Errorf("%s %s: expected the request to be recorded", tc.method, tc.target)
+		}
+	}
+}
+
+func TestMock_transmit_noMatch(t *testing.T) {
+	m := &Mock{}
+	m.Append(&Exchange{Match: Matcher{Method: "GET"}, Response: Response{Status: 200}})
+
+	_, err := m.transmit(httptest.NewRequest("DELETE", "/users/a1", nil))
+	E := "map[match:[no exchange matches DELETE /users/a1]]"
+	if err == nil || err.Error() != E {
+		t.Errorf("expected '%v' got '%v'", E, err)
+	}
+}
+
+func TestMock_transmit_FIFO(t *testing.T) {
+	m := &Mock{FIFO: true}
+	m.Append(&Exchange{Response: Response{Status: 200, Body: "first"}})
+	m.Append(&Exchange{Match: Matcher{Path: "/users"}, Response: Response{Status: 200, Body: "users"}})
+	m.Append(&Exchange{Match: Matcher{Path: "/orders"}, Response: Response{Status: 200, Body: "orders"}})
+
+	res, err := m.transmit(httptest.NewRequest("GET", "/orders", nil))
+	if err != nil || res.Body != "first" {
+		t.Errorf("expected '%v' got '%v' (%v)", "first", res.Body, err)
+	}
+	// the next exchange is for /users
+	_, err = m.transmit(httptest.NewRequest("GET", "/orders", nil))
+	E := "map[match:[GET /orders does not match the next exchange]]"
+	if err == nil || err.Error() != E {
+		t.Errorf("expected '%v' got '%v'", E, err)
+	}
+	res, err = m.transmit(httptest.NewRequest("GET", "/users", nil))
+	if err != nil || res.Body != "users" {
+		t.Errorf("expected '%v' got '%v' (%v)", "users", res.Body, err)
+	}
+}
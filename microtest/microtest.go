@@ -1,6 +1,11 @@
 package microtest
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,6 +15,9 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"testing"
+	"time"
 )
 
 // mock is the interface that connects all microservices
@@ -19,29 +27,107 @@ type mock interface {
 
 // Response contains the basic fields required to mock a response to be
 // expected to be sent back from any microservice.
+//
+// The Delay and Fault of the response simulate a slow or failing
+// microservice.
+//
+// All the values of the Header are written, the Cookies are written as
+// Set-Cookie headers. The body is written with a Content-Length unless
+// Chunked is set or the response has a Trailer.
 type Response struct {
 	Status int
 	Header http.Header
 	Body   string
+	// Cookies are set on the response.
+	Cookies []*http.Cookie
+	// Trailer are the trailers sent after the chunked body.
+	Trailer http.Header
+	// Chunked writes the body with the chunked transfer encoding.
+	Chunked bool
+	// Delay is the latency before the response is written.
+	Delay time.Duration
+	// Fault is the failure injected into the response.
+	Fault Fault
+	// Template is a text/template of the body rendered with the
+	// TemplateData of the request, it replaces the Body.
+	Template string
 }
 
 // Exchange is a Request / Response pair as defined by the IETF RFC2616
 // https://datatracker.ietf.org/doc/html/rfc2616#section-1.4
 // between two servers when using HTTP.
+//
+// The Match of the exchange declares the requests the exchange responds
+// to, an exchange without a Match responds to any request. An exchange
+// responds to the number of requests given by Times. The Expect of the
+// exchange declares the requests the exchange is expected to receive, see
+// Mock.Verify.
 type Exchange struct {
+	Match    Matcher
+	Expect   *Expectation
 	Response Response
 	Request  *http.Request
+	// Respond produces the response from the request, it replaces the
+	// Response.
+	Respond func(RecordedRequest) Response
+	// Times is the number of requests the exchange responds to, default
+	// the Times of the Expect or 1, Forever responds to any number of
+	// requests.
+	Times    int
+	mu       sync.Mutex
+	served   int
+	received []RecordedRequest
+}
+
+// Forever is the Exchange.Times of an exchange that responds to any number
+// of requests.
+const Forever = -1
+
+// exhausted reports whether the exchange responded to all its requests.
+func (e *Exchange) exhausted() bool {
+	times := e.Times
+	if times == 0 && e.Expect != nil && (e.Expect.Times > 0 || e.Expect.Times == Forever) {
+		times = e.Expect.Times
+	}
+	if times == 0 {
+		times = 1
+	}
+	return times > 0 && e.served >= times
 }
 
 // Mock server structure that groups the URL to which the mock server should
 // connect, the mock server itself, the series of exchanges as defined by an
 // Exchange and a counter to count the number of transmissions that have
 // occurred.
+//
+// A request is responded to by the exchange that best matches the request,
+// of the exchanges that match equally well the first appended exchange
+// responds. When FIFO is set the exchanges respond strictly in the order
+// in which they were appended and the request must match the next exchange.
+//
+// A request that is not responded to by an exchange is responded to with
+// the Default response iff it is set, otherwise the request fails the test
+// TB iff it is set, or is logged, and is responded to with 500 Internal
+// Server Error.
+//
+// The Mock is safe for concurrent use, the exchanges must only be added
+// with Append. An exchange is chosen for one request at a time, so that
+// concurrent requests are responded to by the exchanges in the order in
+// which the requests are received by the mock server.
 type Mock struct {
-	URL          url.URL
-	Server       *httptest.Server
-	Exchanges    []*Exchange
+	URL       url.URL
+	Server    *httptest.Server
+	Exchanges []*Exchange
+	FIFO      bool
+	// Default is the response to the requests that no exchange responds
+	// to.
+	Default *Response
+	// TB is the test failed by the requests that no exchange responds to.
+	TB           testing.TB
+	mu           sync.Mutex
 	transmission int
+	requests     []RecordedRequest
+	unexpected   []RecordedRequest
 }
 
 // MockServer takes any mock or mock-able microservice and creates a
@@ -55,6 +141,32 @@ func MockServer(mx mock) *Mock {
 	return m
 }
 
+// TLSOptions configures the TLS of a mock server created by MockServerTLS.
+type TLSOptions struct {
+	// ClientCAs are the CAs used to verify the client certificates, when
+	// set the mock server requires a client certificate (mutual TLS).
+	ClientCAs *x509.CertPool
+}
+
+// MockServerTLS is the same as MockServer, however, the mock server uses
+// TLS with a self-signed certificate. The client must trust the certificate
+// given by CertificatePEM.
+func MockServerTLS(mx mock, opts TLSOptions) *Mock {
+	m := &Mock{
+		transmission: 0,
+	}
+	m.Server = m.mockServerTLS(mx, opts)
+	return m
+}
+
+// CertificatePEM returns the PEM encoded certificate of a TLS mock server.
+func (m *Mock) CertificatePEM() []byte {
+	if m.Server == nil || m.Server.Certificate() == nil {
+		return nil
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.Server.Certificate().Raw})
+}
+
 // NewMockServer takes any mock or mock-able microservice and creates a
 // mock http.Server and a Mock structure to aggregate all the mocked methods
 // together.
@@ -87,23 +199,45 @@ func (m *Mock) SetURL(scheme string, host string) {
 // type mock interface
 func (m *Mock) mockServer(mx mock) *httptest.Server {
 	mockServer := httptest.NewServer(m.mockHandler())
+	connect(mx, mockServer)
+	return mockServer
+}
+
+// mockServerTLS is the same as mockServer, however, the mock server uses
+// TLS and optionally requires client certificates.
+func (m *Mock) mockServerTLS(mx mock, opts TLSOptions) *httptest.Server {
+	mockServer := httptest.NewUnstartedServer(m.mockHandler())
+	if opts.ClientCAs != nil {
+		mockServer.TLS = &tls.Config{
+			ClientCAs:  opts.ClientCAs,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+		}
+	}
+	mockServer.StartTLS()
+	connect(mx, mockServer)
+	return mockServer
+}
 
+// connect points the type mock interface to the mock server.
+func connect(mx mock, mockServer *httptest.Server) {
 	xs := strings.Split(mockServer.URL, "/")
 	scheme := strings.Replace(xs[0], ":", "", 1)
 	host := strings.Join(xs[2:], "")
 	mx.SetURL(scheme, host)
-	return mockServer
 }
 
 // Append adds an Exchange to the queue (Q) of exchanges between the
-// api-gateway and the microservice. Exchanges in the Q are processed a
-// First-In-First-Out (FIFO) manner.
+// api-gateway and the microservice. Exchanges in the Q are matched to the
+// requests, or processed in a First-In-First-Out (FIFO) manner iff FIFO is
+// set.
 //
 // If a nil Exchange is passed then ignore the exchange.
 func (m *Mock) Append(e *Exchange) {
 	if e == nil {
 		return
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.Exchanges = append(m.Exchanges, e)
 }
 
@@ -111,14 +245,93 @@ func (m *Mock) Append(e *Exchange) {
 // and keeps a reference to the request pointed to and returning the response
 // that should be responded with from the mock microservice.
 func (m *Mock) transmit(r *http.Request) (Response, error) {
-	if m.transmission == len(m.Exchanges) {
-		return Response{}, NewErr("transmission", []string{"exceeded mock request/response exchange transmissions"})
+	// read the body to match it and restore it to be read again
+	var body []byte
+	if r != nil && r.Body != nil {
+		xb, err := io.ReadAll(r.Body)
+		if err != nil {
+			return Response{}, NewErr("body", []string{err.Error()})
+		}
+		_ = r.Body.Close()
+		body = xb
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	e, res, rr, err := m.choose(r, body)
+	if err != nil {
+		return Response{}, err
+	}
+	// the response is produced without holding the lock, so that the
+	// Respond function can use the mock
+	return dynamic(e, res, rr)
+}
+
+// choose records the request and chooses the exchange that responds to the
+// request, the exchange is nil if the Default response responds.
+func (m *Mock) choose(r *http.Request, body []byte) (*Exchange, Response, RecordedRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var rr RecordedRequest
+	if r != nil {
+		rr = record(r, body)
+		m.requests = append(m.requests, rr)
+	}
+
+	var e *Exchange
+	var err *Err
+	available := false
+	if m.FIFO {
+		for _, x := range m.Exchanges {
+			if !x.exhausted() {
+				e = x
+				break
+			}
+		}
+		available = e != nil
+		if e != nil {
+			if ok, _ := e.Match.match(r, body); !ok {
+				e = nil
+				err = NewErr("match", []string{fmt.Sprintf("%s %s does not match the next exchange", r.Method, r.URL.String())})
+			}
+		}
+	} else {
+		best := -1
+		for _, x := range m.Exchanges {
+			if x.exhausted() {
+				continue
+			}
+			available = true
+			if ok, score := x.Match.match(r, body); ok && score > best {
+				e, best = x, score
+			}
+		}
+		if available && e == nil {
+			err = NewErr("match", []string{fmt.Sprintf("no exchange matches %s %s", r.Method, r.URL.String())})
+		}
+	}
+	if !available {
+		err = NewErr("transmission", []string{"exceeded mock request/response exchange transmissions"})
+	}
+
+	if e == nil {
+		if m.Default != nil {
+			return nil, *m.Default, rr, nil
+		}
+		// the test is failed immediately, otherwise the request is
+		// reported by Verify
+		if r != nil && m.TB == nil {
+			m.unexpected = append(m.unexpected, rr)
+		}
+		return nil, Response{}, rr, err
 	}
 
-	e := m.Exchanges[m.transmission]
+	e.mu.Lock()
 	e.Request = r
+	e.served++
+	e.received = append(e.received, rr)
+	e.mu.Unlock()
 	m.transmission++
-	return e.Response, nil
+	return e, e.Response, rr, nil
 }
 
 // mockHandler takes the request properties defined on the Mock and writes
@@ -131,20 +344,43 @@ func (m *Mock) mockHandler() http.HandlerFunc {
 		//log.Println(m.Response.Body)
 		res, err := m.transmit(r)
 		if err != nil {
-			log.Panic(err)
+			m.fail(w, r, err)
+			return
 		}
 
-		for key, values := range res.Header {
-			w.Header().Set(key, values[0])
-		}
-		w.WriteHeader(res.Status)
-		_, err = w.Write([]byte(res.Body))
+		err = respond(w, r, res)
 		if err != nil {
-			log.Panic(err)
+			log.Printf("microtest: %s %s: %v", r.Method, r.URL.String(), err)
 		}
 	}
 }
 
+// fail fails the test, or logs the error, of the request that no exchange
+// responds to and responds with 500 Internal Server Error.
+func (m *Mock) fail(w http.ResponseWriter, r *http.Request, err error) {
+	m.mu.Lock()
+	tb := m.TB
+	m.mu.Unlock()
+	if tb != nil {
+		tb.Errorf("microtest: %s %s: %v", r.Method, r.URL.String(), err)
+	} else {
+		log.Printf("microtest: %s %s: %v", r.Method, r.URL.String(), err)
+	}
+
+	errors := map[string][]string{"microtest": {err.Error()}}
+	if e, ok := err.(*Err); ok {
+		errors = e.errors
+	}
+	xb, _ := json.Marshal(map[string]interface{}{
+		"message": "microtest: no exchange responds to the request",
+		"data":    map[string]interface{}{},
+		"errors":  errors,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	_, _ = w.Write(xb)
+}
+
 // ReadRecorder reads the recorder to get the response and decodes the body
 // to a slice of bytes.
 func ReadRecorder(rec *httptest.ResponseRecorder) (*http.Response, []byte) {
@@ -2,6 +2,8 @@ package microtest
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"github.com/johannesscr/micro/microservice"
 	"io"
@@ -10,8 +12,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestMockServer(t *testing.T) {
@@ -406,3 +411,209 @@ func TestErr_Error(t *testing.T) {
 		t.Errorf("expected '%v' got '%v'", "map[key-value:[error description one error description two]]", eString)
 	}
 }
+
+func TestMockServerTLS(t *testing.T) {
+	ms := MockServerTLS(&Mock{}, TLSOptions{})
+	defer ms.Server.Close()
+	if !strings.HasPrefix(ms.Server.URL, "https://") {
+		t.Errorf("expected an https URL got '%v'", ms.Server.URL)
+	}
+
+	ms.Append(&Exchange{Response: Response{Status: 200, Body: "secure"}})
+
+	// the client trusts the certificate of the mock server
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ms.CertificatePEM()) {
+		t.Fatalf("expected a PEM certificate got '%s'", ms.CertificatePEM())
+	}
+	c := http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	res, err := c.Get(ms.Server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	xb, _ := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if string(xb) != "secure" {
+		t.Errorf("expected '%v' got '%v'", "secure", string(xb))
+	}
+}
+
+func TestMock_concurrent(t *testing.T) {
+	mx := &Mock{}
+	ms := MockServer(mx)
+	defer ms.Server.Close()
+
+	const n = 50
+	wg := sync.WaitGroup{}
+	// append the exchanges while the requests are made, each request
+	// retries until its exchange has been appended
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ms.Append(&Exchange{
+				Match:    Matcher{Path: fmt.Sprintf("/users/%d", i)},
+				Response: Response{Status: 200, Body: strconv.Itoa(i)},
+			})
+		}(i)
+	}
+	bodies := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for attempt := 0; attempt < 100; attempt++ {
+				req, _ := http.NewRequest("POST", fmt.Sprintf("%s/users/%d", mx.URL.String(), i), strings.NewReader("{}"))
+				res, err := http.DefaultClient.Do(req)
+				if err != nil {
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				xb, _ := io.ReadAll(res.Body)
+				_ = res.Body.Close()
+				if res.StatusCode != 200 {
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				bodies[i] = string(xb)
+				return
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, body := range bodies {
+		if body != strconv.Itoa(i) {
+			t.Errorf("expected '%v' got '%v'", strconv.Itoa(i), body)
+		}
+	}
+}
+
+func TestMock_concurrentOrder(t *testing.T) {
+	mx := &Mock{}
+	ms := MockServer(mx)
+	defer ms.Server.Close()
+
+	// exchanges that match equally well respond once each, in order
+	const n = 20
+	for i := 0; i < n; i++ {
+		ms.Append(&Exchange{Response: Response{Status: 200, Body: strconv.Itoa(i)}})
+	}
+	wg := sync.WaitGroup{}
+	bodies := make(chan string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := http.Get(mx.URL.String())
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			xb, _ := io.ReadAll(res.Body)
+			_ = res.Body.Close()
+			bodies <- string(xb)
+		}()
+	}
+	wg.Wait()
+	close(bodies)
+
+	seen := make(map[string]bool)
+	for body := range bodies {
+		if seen[body] {
+			t.Errorf("expected each exchange to respond once got '%v' twice", body)
+		}
+		seen[body] = true
+	}
+	if len(seen) != n {
+		t.Errorf("expected %d got %d", n, len(seen))
+	}
+}
+
+func TestMock_transmit_times(t *testing.T) {
+	m := &Mock{}
+	m.Append(&Exchange{Match: Matcher{Path: "/users"}, Response: Response{Status: 200, Body: "users"}, Times: 2})
+	m.Append(&Exchange{Match: Matcher{Path: "/health"}, Response: Response{Status: 200, Body: "up"}, Times: Forever})
+
+	tt := []struct {
+		target string
+		body   string
+		err    string
+	}{
+		{target: "/users", body: "users"},
+		{target: "/health", body: "up"},
+		{target: "/users", body: "users"},
+		{target: "/users", err: "map[match:[no exchange matches GET /users]]"},
+		{target: "/health", body: "up"},
+		{target: "/health", body: "up"},
+	}
+	for i, tc := range tt {
+		res, err := m.transmit(httptest.NewRequest("GET", tc.target, nil))
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%d: expected '%v' got '%v'", i, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+		if res.Body != tc.body {
+			t.Errorf("%d: expected '%v' got '%v'", i, tc.body, res.Body)
+		}
+	}
+}
+
+func TestMock_Default(t *testing.T) {
+	mx := &Mock{}
+	ms := MockServer(mx)
+	defer ms.Server.Close()
+	ms.Default = &Response{Status: 404, Body: `{"message":"not found"}`}
+	ms.Append(&Exchange{Match: Matcher{Path: "/users"}, Response: Response{Status: 200, Body: "users"}})
+
+	for _, tc := range []struct {
+		target string
+		status int
+	}{
+		{target: "/orders", status: 404},
+		{target: "/users", status: 200},
+		{target: "/users", status: 404},
+	} {
+		res, err := http.Get(mx.URL.String() + tc.target)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_ = res.Body.Close()
+		if res.StatusCode != tc.status {
+			t.Errorf("%s: expected %d got %d", tc.target, tc.status, res.StatusCode)
+		}
+	}
+	if len(ms.unexpected) != 0 {
+		t.Errorf("expected no unexpected requests got %d", len(ms.unexpected))
+	}
+}
+
+func TestMock_TB(t *testing.T) {
+	rt := &recorder{TB: t}
+	mx := &Mock{}
+	ms := MockServer(mx)
+	defer ms.Server.Close()
+	ms.TB = rt
+
+	res, err := http.Get(mx.URL.String() + "/users")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	xb, _ := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if res.StatusCode != 500 {
+		t.Errorf("expected %d got %d", 500, res.StatusCode)
+	}
+	E := `{"data":{},"errors":{"transmission":["exceeded mock request/response exchange transmissions"]},"message":"microtest: no exchange responds to the request"}`
+	if string(xb) != E {
+		t.Errorf("expected '%v' got '%v'", E, string(xb))
+	}
+	if len(rt.errors) != 1 || !strings.HasPrefix(rt.errors[0], "microtest: GET /users: ") {
+		t.Errorf("expected the test to fail got '%v'", rt.errors)
+	}
+}
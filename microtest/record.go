@@ -0,0 +1,111 @@
+package microtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RecordedRequest is an immutable snapshot of a request received by the
+// mock server, which remains available after the call completed.
+type RecordedRequest struct {
+	method     string
+	url        url.URL
+	header     http.Header
+	body       []byte
+	isJSON     bool
+	receivedAt time.Time
+}
+
+// record takes a snapshot of the request and its body.
+func record(r *http.Request, body []byte) RecordedRequest {
+	rr := RecordedRequest{
+		method:     r.Method,
+		header:     r.Header.Clone(),
+		body:       append([]byte(nil), body...),
+		isJSON:     len(body) > 0 && json.Valid(body),
+		receivedAt: time.Now(),
+	}
+	if r.URL != nil {
+		rr.url = *r.URL
+		if r.URL.User != nil {
+			u := *r.URL.User
+			rr.url.User = &u
+		}
+	}
+	if rr.header == nil {
+		rr.header = make(http.Header)
+	}
+	return rr
+}
+
+// Method returns the method of the request.
+func (rr RecordedRequest) Method() string {
+	return rr.method
+}
+
+// URL returns the URL of the request.
+func (rr RecordedRequest) URL() url.URL {
+	return rr.url
+}
+
+// Header returns a copy of the headers of the request.
+func (rr RecordedRequest) Header() http.Header {
+	return rr.header.Clone()
+}
+
+// Body returns a copy of the body of the request.
+func (rr RecordedRequest) Body() []byte {
+	return append([]byte(nil), rr.body...)
+}
+
+// JSON returns the decoded JSON body of the request, it is nil if the body
+// is not JSON. Objects are decoded to map[string]interface{}, use Decode to
+// decode the body to a struct.
+func (rr RecordedRequest) JSON() interface{} {
+	if !rr.isJSON {
+		return nil
+	}
+	// decode on each call so that the caller cannot alter the snapshot
+	var v interface{}
+	_ = json.Unmarshal(rr.body, &v)
+	return v
+}
+
+// Decode unmarshals the JSON body of the request into v.
+func (rr RecordedRequest) Decode(v interface{}) error {
+	return json.Unmarshal(rr.body, v)
+}
+
+// ReceivedAt returns the time at which the request was received.
+func (rr RecordedRequest) ReceivedAt() time.Time {
+	return rr.receivedAt
+}
+
+// Requests returns the snapshots of the requests received by the exchange
+// in the order in which they were received.
+func (e *Exchange) Requests() []RecordedRequest {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]RecordedRequest(nil), e.received...)
+}
+
+// Last returns the snapshot of the last request received by the exchange.
+func (e *Exchange) Last() (RecordedRequest, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.received) == 0 {
+		return RecordedRequest{}, false
+	}
+	return e.received[len(e.received)-1], true
+}
+
+// Requests returns the snapshots of all the requests received by the mock
+// server, including the requests that did not match an exchange, in the
+// order in which they were received.
+func (m *Mock) Requests() []RecordedRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]RecordedRequest(nil), m.requests...)
+}
@@ -0,0 +1,83 @@
+package microtest
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExchange_Requests(t *testing.T) {
+	mx := &Mock{}
+	ms := MockServer(mx)
+	defer ms.Server.Close()
+
+	e := &Exchange{Match: Matcher{Path: "/users"}, Response: Response{Status: 201}}
+	ms.Append(e)
+
+	start := time.Now()
+	req, _ := http.NewRequest("POST", mx.URL.String()+"/users?notify=true", strings.NewReader(`{"name":"jane","roles":["admin"]}`))
+	req.Header.Set("X-User-Token", "token")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = res.Body.Close()
+	// a request that does not match an exchange
+	res, err = http.Post(mx.URL.String()+"/orders", "text/plain", strings.NewReader("hi"))
+	if err == nil {
+		_ = res.Body.Close()
+	}
+
+	rs := e.Requests()
+	if len(rs) != 1 {
+		t.Fatalf("expected 1 got %d", len(rs))
+	}
+	r, ok := e.Last()
+	if !ok {
+		t.Fatalf("expected a request")
+	}
+	URL := r.URL()
+	if r.Method() != "POST" || URL.Path != "/users" || URL.Query().Get("notify") != "true" {
+		t.Errorf("expected 'POST /users?notify=true' got '%v %v'", r.Method(), URL.String())
+	}
+	if h := r.Header().Get("X-User-Token"); h != "token" {
+		t.Errorf("expected '%v' got '%v'", "token", h)
+	}
+	if string(r.Body()) != `{"name":"jane","roles":["admin"]}` {
+		t.Errorf("expected '%v' got '%v'", `{"name":"jane","roles":["admin"]}`, string(r.Body()))
+	}
+	v, _ := r.JSON().(map[string]interface{})
+	if v["name"] != "jane" {
+		t.Errorf("expected '%v' got '%v'", "jane", v["name"])
+	}
+	user := struct {
+		Roles []string `json:"roles"`
+	}{}
+	if err := r.Decode(&user); err != nil || len(user.Roles) != 1 || user.Roles[0] != "admin" {
+		t.Errorf("expected '%v' got '%v' (%v)", []string{"admin"}, user.Roles, err)
+	}
+	if r.ReceivedAt().Before(start) {
+		t.Errorf("expected the request to be received after %v got %v", start, r.ReceivedAt())
+	}
+
+	// the snapshot cannot be altered
+	r.Header().Set("X-User-Token", "altered")
+	r.Body()[0] = 'x'
+	v["name"] = "altered"
+	r, _ = e.Last()
+	if r.Header().Get("X-User-Token") != "token" || r.Body()[0] != '{' {
+		t.Errorf("expected the snapshot to be immutable")
+	}
+	if v, _ := r.JSON().(map[string]interface{}); v["name"] != "jane" {
+		t.Errorf("expected '%v' got '%v'", "jane", v["name"])
+	}
+
+	all := ms.Requests()
+	if len(all) != 2 {
+		t.Fatalf("expected 2 got %d", len(all))
+	}
+	if all[1].JSON() != nil || string(all[1].Body()) != "hi" {
+		t.Errorf("expected the non-JSON body 'hi' got '%v'", string(all[1].Body()))
+	}
+}
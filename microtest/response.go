@@ -0,0 +1,83 @@
+package microtest
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// write writes the response with all the header values, the cookies, the
+// body, either chunked or with a Content-Length, and the trailers.
+func write(w http.ResponseWriter, res Response, body []byte) error {
+	h := w.Header()
+	for key, values := range res.Header {
+		for _, value := range values {
+			h.Add(key, value)
+		}
+	}
+	for _, cookie := range res.Cookies {
+		http.SetCookie(w, cookie)
+	}
+	// trailers are only sent with a chunked body
+	chunked := res.Chunked || len(res.Trailer) > 0
+	for key := range res.Trailer {
+		h.Add("Trailer", key)
+	}
+	if !chunked && h.Get("Content-Length") == "" {
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	w.WriteHeader(res.Status)
+
+	flusher, _ := w.(http.Flusher)
+	if chunked && flusher != nil {
+		// flushing the headers before the body omits the Content-Length
+		flusher.Flush()
+	}
+	_, err := w.Write(body)
+	if err != nil {
+		return err
+	}
+	for key, values := range res.Trailer {
+		for _, value := range values {
+			h.Add(key, value)
+		}
+	}
+	return nil
+}
+
+// EnvelopeResponse creates a response with the standard envelope of a
+// microservice, i.e. {"message": ..., "data": ..., "errors": ...}. The data
+// is marshalled to JSON, so that the response is in sync with the model
+// types. A nil data or errors is an empty object.
+func EnvelopeResponse(status int, message string, data interface{}, errors map[string][]string) Response {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	if errors == nil {
+		errors = map[string][]string{}
+	}
+	return JSONResponse(status, struct {
+		Message string              `json:"message"`
+		Data    interface{}         `json:"data"`
+		Errors  map[string][]string `json:"errors"`
+	}{
+		Message: message,
+		Data:    data,
+		Errors:  errors,
+	})
+}
+
+// JSONResponse creates a response with the value marshalled to JSON as the
+// body and the Content-Type application/json.
+func JSONResponse(status int, v interface{}) Response {
+	xb, err := json.Marshal(v)
+	if err != nil {
+		log.Panicf("unexpected err: %v", err)
+	}
+	return Response{
+		Status: status,
+		Header: http.Header{"Content-Type": {"application/json"}},
+		Body:   string(xb),
+	}
+}
@@ -0,0 +1,145 @@
+package microtest
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/johannesscr/micro/microservice"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestEnvelopeResponse(t *testing.T) {
+	user := microservice.User{
+		UUID:      uuid.MustParse("6a67f46e-d9de-4d63-8283-bf5a5aa1e582"),
+		FirstName: "james",
+		LastName:  "bond",
+		Email:     "007@mi6.co.uk",
+	}
+	tt := []struct {
+		name   string
+		res    Response
+		status int
+		body   string
+	}{
+		{
+			name:   "data",
+			res:    EnvelopeResponse(200, "user found", map[string]interface{}{"user": user}, nil),
+			status: 200,
+			body:   `{"message":"user found","data":{"user":{"uuid":"6a67f46e-d9de-4d63-8283-bf5a5aa1e582","first_name":"james","last_name":"bond","email":"007@mi6.co.uk"}},"errors":{}}`,
+		},
+		{
+			name:   "errors",
+			res:    EnvelopeResponse(404, "not found", nil, map[string][]string{"user": {"not found"}}),
+			status: 404,
+			body:   `{"message":"not found","data":{},"errors":{"user":["not found"]}}`,
+		},
+		{
+			name:   "json",
+			res:    JSONResponse(201, []microservice.User{user}),
+			status: 201,
+			body:   `[{"uuid":"6a67f46e-d9de-4d63-8283-bf5a5aa1e582","first_name":"james","last_name":"bond","email":"007@mi6.co.uk"}]`,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.res.Status != tc.status {
+				t.Errorf("expected %d got %d", tc.status, tc.res.Status)
+			}
+			if tc.res.Body != tc.body {
+				t.Errorf("expected '%v' got '%v'", tc.body, tc.res.Body)
+			}
+			if ct := tc.res.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("expected '%v' got '%v'", "application/json", ct)
+			}
+			if !json.Valid([]byte(tc.res.Body)) {
+				t.Errorf("expected valid JSON")
+			}
+		})
+	}
+}
+
+func TestEnvelopeResponse_GetUser(t *testing.T) {
+	s := microservice.NewService()
+	ms := MockServer(s)
+	defer ms.Server.Close()
+
+	E := microservice.User{UUID: uuid.New(), FirstName: "james", LastName: "bond"}
+	ms.Append(&Exchange{Response: EnvelopeResponse(200, "user found", map[string]interface{}{"user": E}, nil)})
+	u, errors := s.GetUser(E.UUID.String())
+	if errors != nil {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	if u != E {
+		t.Errorf("expected '%v' got '%v'", E, u)
+	}
+}
+
+func TestRespond_write(t *testing.T) {
+	body := `{"message":"ok","data":{},"errors":{}}`
+	header := http.Header{
+		"Content-Type": {"application/json"},
+		"Link":         {"</users?page=2>; rel=\"next\"", "</users?page=9>; rel=\"last\""},
+	}
+	cookies := []*http.Cookie{
+		{Name: "session", Value: "abc", Path: "/", HttpOnly: true},
+		{Name: "theme", Value: "dark", MaxAge: 3600},
+	}
+	type E struct {
+		chunked       bool
+		contentLength int64
+		trailer       string
+	}
+	tt := []struct {
+		name     string
+		response Response
+		E        E
+	}{
+		{name: "content length", response: Response{Status: 200, Header: header, Body: body, Cookies: cookies}, E: E{contentLength: int64(len(body))}},
+		{name: "chunked", response: Response{Status: 200, Header: header, Body: body, Cookies: cookies, Chunked: true}, E: E{chunked: true, contentLength: -1}},
+		{name: "trailer", response: Response{Status: 200, Header: header, Body: body, Cookies: cookies, Trailer: http.Header{"X-Checksum": {"a1b2"}}}, E: E{chunked: true, contentLength: -1, trailer: "a1b2"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			mx := &Mock{}
+			ms := MockServer(mx)
+			defer ms.Server.Close()
+			ms.Append(&Exchange{Response: tc.response})
+
+			res, err := http.Get(mx.URL.String())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer func() {
+				_ = res.Body.Close()
+			}()
+			// the trailers are only available once the body is read
+			xb, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if string(xb) != body {
+				t.Errorf("expected '%v' got '%v'", body, string(xb))
+			}
+			if links := res.Header.Values("Link"); len(links) != 2 || links[1] != header["Link"][1] {
+				t.Errorf("expected '%v' got '%v'", header["Link"], links)
+			}
+			got := res.Cookies()
+			if len(got) != 2 || got[0].Value != "abc" || !got[0].HttpOnly || got[1].MaxAge != 3600 {
+				t.Errorf("expected '%v' got '%v'", cookies, got)
+			}
+			chunked := len(res.TransferEncoding) == 1 && res.TransferEncoding[0] == "chunked"
+			if chunked != tc.E.chunked {
+				t.Errorf("expected '%v' got '%v'", tc.E.chunked, res.TransferEncoding)
+			}
+			if res.ContentLength != tc.E.contentLength {
+				t.Errorf("expected %d got %d", tc.E.contentLength, res.ContentLength)
+			}
+			if v := res.Trailer.Get("X-Checksum"); v != tc.E.trailer {
+				t.Errorf("expected '%v' got '%v'", tc.E.trailer, v)
+			}
+		})
+	}
+}
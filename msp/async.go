@@ -0,0 +1,185 @@
+package msp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/dottics/dutil"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// PollOptions configures how the status resource of a long-running
+// operation is polled after a microservice responded 202 Accepted.
+type PollOptions struct {
+	// Interval is the initial wait between polls, default 500ms.
+	Interval time.Duration
+	// MaxInterval is the maximum wait between polls, default 30s.
+	MaxInterval time.Duration
+	// Multiplier is the factor the wait increases by after each poll,
+	// default 2.
+	Multiplier float64
+	// Timeout is the maximum time to wait for the operation to complete,
+	// zero means the operation is polled until the context is done.
+	Timeout time.Duration
+	// Done reports whether the status response is in a terminal state. By
+	// default any response other than 202 Accepted is terminal.
+	Done func(res *http.Response, body []byte) bool
+}
+
+// withDefaults returns the options with the defaults set for the options
+// that are not set.
+func (o PollOptions) withDefaults() PollOptions {
+	if o.Interval <= 0 {
+		o.Interval = 500 * time.Millisecond
+	}
+	if o.MaxInterval <= 0 {
+		o.MaxInterval = 30 * time.Second
+	}
+	if o.Multiplier < 1 {
+		o.Multiplier = 2
+	}
+	if o.Done == nil {
+		o.Done = func(res *http.Response, _ []byte) bool {
+			return res.StatusCode != http.StatusAccepted
+		}
+	}
+	return o
+}
+
+// DoAsync makes the request and if the microservice starts a long-running
+// operation, by responding 202 Accepted with a Location header, it polls
+// the status resource until the operation completes. The body of the final
+// response is decoded into v if v is provided.
+func (s *Service) DoAsync(ctx context.Context, method string, URL url.URL, query url.Values, headers http.Header, payload io.Reader, v interface{}, opts PollOptions) ([]byte, dutil.Error) {
+	res, e := s.DoRequestContext(ctx, method, URL, query, headers, payload)
+	if e != nil {
+		return nil, e
+	}
+	if res.StatusCode == http.StatusAccepted {
+		res, e = s.Poll(ctx, res, opts)
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	xb, e := s.Decode(res, nil)
+	if e != nil {
+		return nil, e
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return xb, ResponseError(res, xb)
+	}
+	if v != nil {
+		err := unmarshal(xb, v, s.DecodeOptions)
+		if err != nil {
+			return nil, unmarshalErr(err, xb)
+		}
+	}
+	return xb, nil
+}
+
+// Poll polls the status resource given by the Location header of the 202
+// Accepted response with an exponential backoff, honouring the Retry-After
+// header, until the status response is in a terminal state. The terminal
+// response is returned with its body unread.
+func (s *Service) Poll(ctx context.Context, res *http.Response, opts PollOptions) (*http.Response, dutil.Error) {
+	opts = opts.withDefaults()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	// a fallback response would be taken as the terminal state of the
+	// operation
+	ctx = WithFallback(ctx, nil)
+
+	location, e := statusLocation(res)
+	if e != nil {
+		return nil, e
+	}
+	wait := opts.Interval
+	for {
+		delay := wait
+		if d, ok := retryAfter(res.Header); ok {
+			delay = d
+		}
+		_, e = s.Decode(res, nil)
+		if e != nil {
+			return nil, e
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, dutil.NewErr(500, "timeout", []string{
+				fmt.Sprintf("operation at %s did not complete: %v", location.String(), ctx.Err()),
+			})
+		case <-t.C:
+		}
+
+		u := *location
+		u.RawQuery = ""
+		res, e = s.DoRequestContext(ctx, "GET", u, location.Query(), nil, nil)
+		if e != nil {
+			if ctx.Err() != nil {
+				return nil, dutil.NewErr(500, "timeout", []string{
+					fmt.Sprintf("operation at %s did not complete: %v", location.String(), ctx.Err()),
+				})
+			}
+			return nil, e
+		}
+		xb, e := s.Decode(res, nil)
+		if e != nil {
+			return nil, e
+		}
+		// restore the body for the caller or the next poll
+		res.Body = io.NopCloser(bytes.NewReader(xb))
+		if opts.Done(res, xb) {
+			return res, nil
+		}
+		// the status resource may move the operation to a new location
+		if l, err := res.Location(); err == nil {
+			location = l
+		}
+
+		wait = time.Duration(float64(wait) * opts.Multiplier)
+		if wait > opts.MaxInterval {
+			wait = opts.MaxInterval
+		}
+	}
+}
+
+// statusLocation returns the URL of the status resource resolved against
+// the URL of the request of the response.
+func statusLocation(res *http.Response) (*url.URL, dutil.Error) {
+	location, err := res.Location()
+	if err != nil {
+		return nil, dutil.NewErr(500, "location", []string{err.Error()})
+	}
+	return location, nil
+}
+
+// retryAfter returns the duration to wait as given by the Retry-After
+// header in either seconds or as an HTTP-date.
+func retryAfter(h http.Header) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
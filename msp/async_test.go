@@ -0,0 +1,160 @@
+package msp
+
+import (
+	"context"
+	"github.com/johannesscr/micro/microtest"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestService_DoAsync(t *testing.T) {
+	accepted := func(location string) *microtest.Exchange {
+		return &microtest.Exchange{Response: microtest.Response{
+			Status: 202,
+			Header: http.Header{"Location": {location}, "Retry-After": {"0"}},
+			Body:   `{"message":"accepted"}`,
+		}}
+	}
+	type result struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	tt := []struct {
+		name      string
+		exchanges []*microtest.Exchange
+		fallback  Fallback
+		opts      PollOptions
+		EID       string
+		EURIs     []string
+		EErr      string
+	}{
+		{
+			name: "no async operation",
+			exchanges: []*microtest.Exchange{
+				{Response: microtest.Response{Status: 201, Body: `{"data":{"id":"a"}}`}},
+			},
+			EID:   "a",
+			EURIs: []string{"/jobs"},
+		},
+		{
+			name: "polls until complete",
+			exchanges: []*microtest.Exchange{
+				accepted("/jobs/1?v=1"),
+				accepted("/jobs/1/status"),
+				{Response: microtest.Response{Status: 200, Body: `{"data":{"id":"b"}}`}},
+			},
+			EID:   "b",
+			EURIs: []string{"/jobs", "/jobs/1?v=1", "/jobs/1/status"},
+		},
+		{
+			name: "custom terminal state",
+			exchanges: []*microtest.Exchange{
+				accepted("/jobs/1"),
+				{Response: microtest.Response{Status: 200, Body: `{"data":{"id":"c","status":"running"}}`}},
+				{Response: microtest.Response{Status: 200, Body: `{"data":{"id":"d","status":"succeeded"}}`}},
+			},
+			opts: PollOptions{
+				Interval: time.Millisecond,
+				Done: func(res *http.Response, body []byte) bool {
+					return res.StatusCode != 202 && string(body) != `{"data":{"id":"c","status":"running"}}`
+				},
+			},
+			EID:   "d",
+			EURIs: []string{"/jobs", "/jobs/1", "/jobs/1"},
+		},
+		{
+			name: "operation failed",
+			exchanges: []*microtest.Exchange{
+				accepted("/jobs/1"),
+				{Response: microtest.Response{Status: 500, Body: `{"errors":{"job":["failed"]}}`}},
+			},
+			EErr: "map[job:[failed]]",
+		},
+		{
+			name: "operation failed with a fallback",
+			exchanges: []*microtest.Exchange{
+				accepted("/jobs/1"),
+				{Response: microtest.Response{Status: 500, Body: `{"errors":{"job":["failed"]}}`}},
+			},
+			fallback: StaticFallback(`{"data":{"id":"fallback"}}`),
+			EErr:     "map[job:[failed]]",
+		},
+		{
+			name: "no location",
+			exchanges: []*microtest.Exchange{
+				{Response: microtest.Response{Status: 202}},
+			},
+			EErr: "map[location:[http: no Location header in response]]",
+		},
+		{
+			name: "timeout",
+			exchanges: []*microtest.Exchange{
+				{Response: microtest.Response{Status: 202, Header: http.Header{"Location": {"/jobs/1"}}}},
+			},
+			opts: PollOptions{Interval: time.Second, Timeout: 10 * time.Millisecond},
+			EErr: "map[timeout:[operation at http://" + "HOST" + "/jobs/1 did not complete: context deadline exceeded]]",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewService(Config{Name: "micro", Fallback: tc.fallback})
+			ms := microtest.MockServer(s)
+			defer ms.Server.Close()
+			for _, ex := range tc.exchanges {
+				ms.Append(ex)
+			}
+
+			s.URL.Path = "/jobs"
+			v := result{}
+			_, e := s.DoAsync(context.Background(), "POST", s.URL, nil, nil, nil, &v, tc.opts)
+			if tc.EErr != "" {
+				EErr := strings.Replace(tc.EErr, "HOST", s.URL.Host, 1)
+				if e == nil || e.Error() != EErr {
+					t.Errorf("expected '%v' got '%v'", EErr, e)
+				}
+				return
+			}
+			if e != nil {
+				t.Fatalf("unexpected error: %v", e)
+			}
+			if v.Data.ID != tc.EID {
+				t.Errorf("expected '%v' got '%v'", tc.EID, v.Data.ID)
+			}
+			for i, uri := range tc.EURIs {
+				if ms.Exchanges[i].Request.RequestURI != uri {
+					t.Errorf("expected '%v' got '%v'", uri, ms.Exchanges[i].Request.RequestURI)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tt := []struct {
+		name   string
+		header string
+		E      time.Duration
+		Eok    bool
+	}{
+		{name: "none", header: ""},
+		{name: "seconds", header: "3", E: 3 * time.Second, Eok: true},
+		{name: "past date", header: "Wed, 21 Oct 2015 07:28:00 GMT", E: 0, Eok: true},
+		{name: "invalid", header: "soon"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			h := http.Header{}
+			if tc.header != "" {
+				h.Set("Retry-After", tc.header)
+			}
+			d, ok := retryAfter(h)
+			if d != tc.E || ok != tc.Eok {
+				t.Errorf("expected '%v %v' got '%v %v'", tc.E, tc.Eok, d, ok)
+			}
+		})
+	}
+}
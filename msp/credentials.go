@@ -0,0 +1,139 @@
+package msp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CredentialProvider provides a credential, such as a user token or an API
+// key, it is consulted each time a request is made so that credentials can
+// be rotated without creating a new Service.
+type CredentialProvider interface {
+	Credential(ctx context.Context) (string, error)
+}
+
+// Invalidator is implemented by a CredentialProvider that caches its
+// credential, Invalidate discards the cached credential so that the next
+// request obtains a fresh credential.
+type Invalidator interface {
+	Invalidate()
+}
+
+// CredentialFunc is an adapter to use a function as a CredentialProvider.
+type CredentialFunc func(ctx context.Context) (string, error)
+
+// Credential calls f(ctx).
+func (f CredentialFunc) Credential(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// StaticCredential is a credential that never changes.
+type StaticCredential string
+
+// Credential returns the static credential.
+func (c StaticCredential) Credential(context.Context) (string, error) {
+	return string(c), nil
+}
+
+// EnvCredential is the name of the environmental variable the credential
+// is read from on each request.
+type EnvCredential string
+
+// Credential returns the value of the environmental variable, an error is
+// returned if the variable is not set.
+func (c EnvCredential) Credential(context.Context) (string, error) {
+	v, ok := os.LookupEnv(string(c))
+	if !ok {
+		return "", fmt.Errorf("environmental variable %s is not set", string(c))
+	}
+	return v, nil
+}
+
+// FileCredential is a credential read from a file, such as a Kubernetes
+// secret mount. The file is only read again once its modification time or
+// size has changed.
+type FileCredential struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	value   string
+}
+
+// NewFileCredential creates a FileCredential for the file at the path.
+func NewFileCredential(path string) *FileCredential {
+	return &FileCredential{path: path}
+}
+
+// Credential returns the content of the file with the surrounding white
+// space removed.
+func (c *FileCredential) Credential(context.Context) (string, error) {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !info.ModTime().Equal(c.modTime) || info.Size() != c.size || c.value == "" {
+		xb, err := os.ReadFile(c.path)
+		if err != nil {
+			return "", err
+		}
+		c.value = strings.TrimSpace(string(xb))
+		c.modTime = info.ModTime()
+		c.size = info.Size()
+	}
+	return c.value, nil
+}
+
+// CachedCredential caches the credential of a provider, which is expensive
+// to consult, for a time-to-live after which the credential is refreshed.
+// Concurrent requests wait for a single refresh.
+type CachedCredential struct {
+	provider CredentialProvider
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	value   string
+	expires time.Time
+}
+
+// NewCachedCredential creates a CachedCredential that refreshes the
+// credential from the provider after the ttl.
+func NewCachedCredential(provider CredentialProvider, ttl time.Duration) *CachedCredential {
+	return &CachedCredential{
+		provider: provider,
+		ttl:      ttl,
+	}
+}
+
+// Credential returns the cached credential, refreshing it from the provider
+// if it has expired.
+func (c *CachedCredential) Credential(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.expires.IsZero() && time.Now().Before(c.expires) {
+		return c.value, nil
+	}
+	v, err := c.provider.Credential(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.value = v
+	c.expires = time.Now().Add(c.ttl)
+	return v, nil
+}
+
+// Invalidate discards the cached credential so that the next request
+// refreshes it.
+func (c *CachedCredential) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expires = time.Time{}
+}
@@ -0,0 +1,138 @@
+package msp
+
+import (
+	"context"
+	"errors"
+	"github.com/johannesscr/micro/microtest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnvCredential(t *testing.T) {
+	err := os.Setenv("MICRO_TEST_API_KEY", "key-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Unsetenv("MICRO_TEST_API_KEY")
+
+	c := EnvCredential("MICRO_TEST_API_KEY")
+	v, err := c.Credential(context.Background())
+	if err != nil || v != "key-1" {
+		t.Errorf("expected '%v' got '%v' %v", "key-1", v, err)
+	}
+
+	_ = os.Setenv("MICRO_TEST_API_KEY", "key-2")
+	v, _ = c.Credential(context.Background())
+	if v != "key-2" {
+		t.Errorf("expected '%v' got '%v'", "key-2", v)
+	}
+
+	_, err = EnvCredential("MICRO_TEST_NOT_SET").Credential(context.Background())
+	if err == nil {
+		t.Errorf("expected an error got nil")
+	}
+}
+
+func TestFileCredential(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	err := os.WriteFile(path, []byte("token-1\n"), 0600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c := NewFileCredential(path)
+	v, err := c.Credential(context.Background())
+	if err != nil || v != "token-1" {
+		t.Errorf("expected '%v' got '%v' %v", "token-1", v, err)
+	}
+
+	// rotate the secret
+	err = os.WriteFile(path, []byte("token-333"), 0600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	v, _ = c.Credential(context.Background())
+	if v != "token-333" {
+		t.Errorf("expected '%v' got '%v'", "token-333", v)
+	}
+
+	_, err = NewFileCredential(filepath.Join(t.TempDir(), "none")).Credential(context.Background())
+	if err == nil {
+		t.Errorf("expected an error got nil")
+	}
+}
+
+func TestCachedCredential(t *testing.T) {
+	calls := 0
+	p := CredentialFunc(func(ctx context.Context) (string, error) {
+		calls++
+		if calls == 3 {
+			return "", errors.New("unavailable")
+		}
+		return "token", nil
+	})
+
+	c := NewCachedCredential(p, 20*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		v, err := c.Credential(context.Background())
+		if err != nil || v != "token" {
+			t.Errorf("expected '%v' got '%v' %v", "token", v, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 got %d", calls)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	_, _ = c.Credential(context.Background())
+	if calls != 2 {
+		t.Errorf("expected 2 got %d", calls)
+	}
+
+	c.Invalidate()
+	_, err := c.Credential(context.Background())
+	if err == nil {
+		t.Errorf("expected an error got nil")
+	}
+}
+
+func TestService_DoRequest_credentials(t *testing.T) {
+	token := "token-1"
+	s := NewService(Config{
+		Name:   "micro",
+		APIKey: "static-key",
+		UserTokenProvider: CredentialFunc(func(ctx context.Context) (string, error) {
+			return token, nil
+		}),
+	})
+	ms := microtest.MockServer(s)
+	defer ms.Server.Close()
+
+	for _, E := range []string{"token-1", "token-2"} {
+		token = E
+		ex := &microtest.Exchange{Response: microtest.Response{Status: 200}}
+		ms.Append(ex)
+		_, e := s.DoRequest("GET", s.URL, nil, nil, nil)
+		if e != nil {
+			t.Fatalf("unexpected error: %v", e)
+		}
+		if h := ex.Request.Header.Get("X-User-Token"); h != E {
+			t.Errorf("expected '%v' got '%v'", E, h)
+		}
+		if h := ex.Request.Header.Get("X-Api-Key"); h != "static-key" {
+			t.Errorf("expected '%v' got '%v'", "static-key", h)
+		}
+	}
+	if len(s.Header.Values("X-User-Token")) != 0 {
+		t.Errorf("expected no default user token header got '%v'", s.Header.Values("X-User-Token"))
+	}
+
+	s.Credentials["X-Api-Key"] = EnvCredential("MICRO_TEST_NOT_SET")
+	_, e := s.DoRequest("GET", s.URL, nil, nil, nil)
+	E := "map[credential:[X-Api-Key: environmental variable MICRO_TEST_NOT_SET is not set]]"
+	if e == nil || e.Error() != E {
+		t.Errorf("expected '%v' got '%v'", E, e)
+	}
+}
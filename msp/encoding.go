@@ -1,17 +1,57 @@
 package msp
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/dottics/dutil"
 	"io"
 	"net/http"
 )
 
+// excerptSize is the maximum number of bytes of a response body that is
+// included in a decode error to help with debugging.
+const excerptSize = 256
+
+// DecodeOptions are the options used to read and unmarshal a response body.
+// The zero value reads the complete body and unmarshals it leniently, which
+// is the behaviour of Decode.
+type DecodeOptions struct {
+	// MaxBodySize is the maximum number of bytes read from a response body,
+	// a body larger than MaxBodySize results in an error. Zero or a negative
+	// value means there is no limit.
+	MaxBodySize int64
+	// DisallowUnknownFields rejects a body containing fields that do not
+	// match any field of the value being decoded into.
+	DisallowUnknownFields bool
+	// UseNumber unmarshals numbers into an interface{} as a json.Number
+	// instead of a float64 to preserve their precision.
+	UseNumber bool
+}
+
 // Decode is a function that decodes a body into a slice of bytes and also
 // will unmarshal the data into an interface pointer value if the value
 // pointed to by the interface is provided.
 func Decode(res *http.Response, v interface{}) ([]byte, dutil.Error) {
-	xb, err := io.ReadAll(res.Body)
+	return DecodeWith(res, v, DecodeOptions{})
+}
+
+// Decode decodes the response body the same as the Decode function, however,
+// it applies the DecodeOptions configured for the service.
+func (s *Service) Decode(res *http.Response, v interface{}) ([]byte, dutil.Error) {
+	return DecodeWith(res, v, s.DecodeOptions)
+}
+
+// DecodeWith decodes a body into a slice of bytes and unmarshals the data
+// into v, if v is provided, applying the given DecodeOptions.
+func DecodeWith(res *http.Response, v interface{}, opts DecodeOptions) ([]byte, dutil.Error) {
+	var r io.Reader = res.Body
+	if opts.MaxBodySize > 0 {
+		// read one byte more than allowed to know if the limit is exceeded
+		r = io.LimitReader(res.Body, opts.MaxBodySize+1)
+	}
+	xb, err := io.ReadAll(r)
 	if err != nil {
 		e := dutil.NewErr(500, "read", []string{err.Error()})
 		return nil, e
@@ -21,12 +61,58 @@ func Decode(res *http.Response, v interface{}) ([]byte, dutil.Error) {
 		e := dutil.NewErr(500, "readClose", []string{err.Error()})
 		return nil, e
 	}
+	if opts.MaxBodySize > 0 && int64(len(xb)) > opts.MaxBodySize {
+		e := dutil.NewErr(500, "maxBodySize", []string{
+			fmt.Sprintf("response body exceeds the maximum size of %d bytes", opts.MaxBodySize),
+		})
+		return nil, e
+	}
 	if v != nil {
-		err = json.Unmarshal(xb, v)
+		err = unmarshal(xb, v, opts)
 		if err != nil {
-			e := dutil.NewErr(500, "unmarshal", []string{err.Error()})
-			return nil, e
+			return nil, unmarshalErr(err, xb)
 		}
 	}
 	return xb, nil
 }
+
+// unmarshal unmarshals xb into v, a json.Decoder is only used when one of
+// the decoder options is required.
+func unmarshal(xb []byte, v interface{}, opts DecodeOptions) error {
+	if !opts.DisallowUnknownFields && !opts.UseNumber {
+		return json.Unmarshal(xb, v)
+	}
+	d := json.NewDecoder(bytes.NewReader(xb))
+	if opts.DisallowUnknownFields {
+		d.DisallowUnknownFields()
+	}
+	if opts.UseNumber {
+		d.UseNumber()
+	}
+	err := d.Decode(v)
+	if err != nil {
+		return err
+	}
+	// the same as json.Unmarshal only a single JSON value is allowed
+	if _, err = d.Token(); err != io.EOF {
+		return errors.New("invalid data after top-level value")
+	}
+	return nil
+}
+
+// unmarshalErr is the error returned when the body xb could not be
+// unmarshalled, it includes an excerpt of the body.
+func unmarshalErr(err error, xb []byte) *dutil.Err {
+	e := dutil.NewErr(500, "unmarshal", []string{err.Error()})
+	e.Errors["body"] = []string{excerpt(xb)}
+	return e
+}
+
+// excerpt returns the body truncated to at most excerptSize bytes, marking
+// the body as truncated if it was shortened.
+func excerpt(xb []byte) string {
+	if len(xb) <= excerptSize {
+		return string(xb)
+	}
+	return fmt.Sprintf("%s... (%d bytes truncated)", xb[:excerptSize], len(xb)-excerptSize)
+}
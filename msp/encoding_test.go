@@ -1,6 +1,7 @@
 package msp
 
 import (
+	"encoding/json"
 	"github.com/dottics/dutil"
 	"io/ioutil"
 	"net/http"
@@ -47,6 +48,7 @@ func TestDecode(t *testing.T) {
 					Status: 500,
 					Errors: map[string][]string{
 						"unmarshal": {"json: cannot unmarshal number into Go struct field payload.name of type string"},
+						"body":      {`{"name":1}`},
 					},
 				},
 			},
@@ -86,3 +88,111 @@ func TestDecode(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeWith(t *testing.T) {
+	type payload struct {
+		Name string `json:"name"`
+	}
+	tt := []struct {
+		name string
+		body string
+		v    interface{}
+		opts DecodeOptions
+		E    string
+		EErr string
+	}{
+		{
+			name: "within max body size",
+			body: `{"name":"james"}`,
+			v:    &payload{},
+			opts: DecodeOptions{MaxBodySize: 16},
+			E:    `{"name":"james"}`,
+		},
+		{
+			name: "exceeds max body size",
+			body: `{"name":"james bond"}`,
+			v:    &payload{},
+			opts: DecodeOptions{MaxBodySize: 16},
+			EErr: "map[maxBodySize:[response body exceeds the maximum size of 16 bytes]]",
+		},
+		{
+			name: "unknown fields allowed",
+			body: `{"name":"james","age":7}`,
+			v:    &payload{},
+			E:    `{"name":"james","age":7}`,
+		},
+		{
+			name: "unknown fields disallowed",
+			body: `{"name":"james","age":7}`,
+			v:    &payload{},
+			opts: DecodeOptions{DisallowUnknownFields: true},
+			EErr: `map[body:[{"name":"james","age":7}] unmarshal:[json: unknown field "age"]]`,
+		},
+		{
+			name: "strict trailing data",
+			body: `{"name":"james"}{}`,
+			v:    &payload{},
+			opts: DecodeOptions{DisallowUnknownFields: true},
+			EErr: `map[body:[{"name":"james"}{}] unmarshal:[invalid data after top-level value]]`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			res := &http.Response{
+				Body: ioutil.NopCloser(strings.NewReader(tc.body)),
+			}
+			xb, e := DecodeWith(res, tc.v, tc.opts)
+			if tc.EErr != "" {
+				if e == nil {
+					t.Fatalf("expected '%v' got nil", tc.EErr)
+				}
+				if e.Error() != tc.EErr {
+					t.Errorf("expected '%v' got '%v'", tc.EErr, e.Error())
+				}
+				return
+			}
+			if e != nil {
+				t.Errorf("unexpected error: %v", e)
+			}
+			if string(xb) != tc.E {
+				t.Errorf("expected '%v' got '%v'", tc.E, string(xb))
+			}
+		})
+	}
+}
+
+func TestDecodeWith_excerpt(t *testing.T) {
+	res := &http.Response{
+		Body: ioutil.NopCloser(strings.NewReader(`{"name":` + strings.Repeat("1", 300) + `}`)),
+	}
+	_, e := DecodeWith(res, &struct {
+		Name string `json:"name"`
+	}{}, DecodeOptions{})
+	if e == nil {
+		t.Fatalf("expected an error got nil")
+	}
+	body := dutil.Inst(e).Errors["body"]
+	E := `{"name":` + strings.Repeat("1", 248) + `... (53 bytes truncated)`
+	if len(body) != 1 || body[0] != E {
+		t.Errorf("expected '%v' got '%v'", E, body)
+	}
+}
+
+func TestDecodeWith_useNumber(t *testing.T) {
+	res := &http.Response{
+		Body: ioutil.NopCloser(strings.NewReader(`{"id":9007199254740993}`)),
+	}
+	v := map[string]interface{}{}
+	_, e := DecodeWith(res, &v, DecodeOptions{UseNumber: true})
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	n, ok := v["id"].(json.Number)
+	if !ok {
+		t.Fatalf("expected json.Number got %T", v["id"])
+	}
+	if n.String() != "9007199254740993" {
+		t.Errorf("expected '%v' got '%v'", "9007199254740993", n.String())
+	}
+}
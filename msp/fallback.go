@@ -0,0 +1,278 @@
+package msp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/dottics/dutil"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// ErrCircuitOpen is the error returned by an interceptor that implements a
+// circuit breaker when the circuit is open, the request fails with the
+// error key "circuit" and a fallback is used iff it is set.
+var ErrCircuitOpen = errors.New("msp: circuit open")
+
+// FallbackHeader is the response header set on a fallback response, to
+// the reason of the fallback.
+const FallbackHeader = "X-Fallback"
+
+// Reasons for a fallback.
+const (
+	FallbackTransport = "transport"
+	FallbackCircuit   = "circuit"
+	FallbackStatus    = "status"
+)
+
+// FallbackCall is the failed call for which a fallback is used.
+type FallbackCall struct {
+	Service string
+	Method  string
+	URL     url.URL
+	// Reason is why the call failed, either FallbackTransport,
+	// FallbackCircuit or FallbackStatus.
+	Reason string
+	// StatusCode is the 5xx response status code iff the Reason is
+	// FallbackStatus.
+	StatusCode int
+	// Error is the error of the call iff the Reason is not FallbackStatus.
+	Error dutil.Error
+}
+
+// Fallback provides the response body used in place of a failed call, it
+// returns false if it has no body for the call.
+type Fallback interface {
+	Fallback(ctx context.Context, call FallbackCall) ([]byte, bool)
+}
+
+// FallbackRecorder is a Fallback that records the body of successful
+// calls. A body is recorded once the caller read it to the end, a body
+// larger than the MaxBodySize of the DecodeOptions of the service, or 1 MiB
+// if it is not set, is not recorded.
+type FallbackRecorder interface {
+	Record(ctx context.Context, method string, URL url.URL, body []byte)
+}
+
+// FallbackFunc is an adapter to use a function as a Fallback.
+type FallbackFunc func(ctx context.Context, call FallbackCall) ([]byte, bool)
+
+// Fallback calls f(ctx, call).
+func (f FallbackFunc) Fallback(ctx context.Context, call FallbackCall) ([]byte, bool) {
+	return f(ctx, call)
+}
+
+// StaticFallback is a Fallback with a static response body.
+type StaticFallback []byte
+
+// Fallback returns the static body.
+func (sf StaticFallback) Fallback(context.Context, FallbackCall) ([]byte, bool) {
+	return []byte(sf), true
+}
+
+// LastKnownGood is a Fallback with the body of the last successful call
+// with the same method, URL and discriminator.
+//
+// Without a Discriminator a body is served to every caller of the URL, so
+// a LastKnownGood without a Discriminator is only safe for responses that
+// are the same for every caller, i.e. not for a response that depends on
+// the credentials or headers of the call such as "/me".
+type LastKnownGood struct {
+	// MaxEntries is the maximum number of bodies that are kept, when it is
+	// reached an arbitrary body is evicted, default 1000.
+	MaxEntries int
+	// Discriminator returns the part of the key of a body that identifies
+	// the caller, e.g. the user of the context of the call, a body is only
+	// served to calls with the same discriminator.
+	Discriminator func(ctx context.Context) string
+
+	mu     sync.RWMutex
+	bodies map[string][]byte
+}
+
+// NewLastKnownGood creates an empty LastKnownGood.
+func NewLastKnownGood() *LastKnownGood {
+	return &LastKnownGood{
+		MaxEntries: 1000,
+		bodies:     make(map[string][]byte),
+	}
+}
+
+// Record records the body of the successful call.
+func (l *LastKnownGood) Record(ctx context.Context, method string, URL url.URL, body []byte) {
+	key := l.key(ctx, method, URL)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.bodies == nil {
+		l.bodies = make(map[string][]byte)
+	}
+	max := l.MaxEntries
+	if max <= 0 {
+		max = 1000
+	}
+	if _, ok := l.bodies[key]; !ok && len(l.bodies) >= max {
+		for k := range l.bodies {
+			delete(l.bodies, k)
+			break
+		}
+	}
+	l.bodies[key] = body
+}
+
+// Fallback returns the last recorded body of the call.
+func (l *LastKnownGood) Fallback(ctx context.Context, call FallbackCall) ([]byte, bool) {
+	key := l.key(ctx, call.Method, call.URL)
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	xb, ok := l.bodies[key]
+	return xb, ok
+}
+
+// key returns the key of the body of the call.
+func (l *LastKnownGood) key(ctx context.Context, method string, URL url.URL) string {
+	key := method + " " + URL.String()
+	if l.Discriminator != nil {
+		key = l.Discriminator(ctx) + " " + key
+	}
+	return key
+}
+
+// maxRecordSize is the maximum size of a body recorded for a
+// FallbackRecorder, unless the DecodeOptions of the service limit the size
+// of the bodies.
+const maxRecordSize = 1 << 20
+
+// recordedBody streams the body of a successful response and records the
+// body once it is read to the end, a body larger than max is not recorded.
+type recordedBody struct {
+	io.ReadCloser
+	max    int64
+	buf    []byte
+	skip   bool
+	record func([]byte)
+}
+
+func (b *recordedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.skip {
+		if int64(len(b.buf)+n) > b.max {
+			b.skip, b.buf = true, nil
+		} else {
+			b.buf = append(b.buf, p[:n]...)
+		}
+	}
+	if err == io.EOF && !b.skip {
+		b.skip = true
+		b.record(b.buf)
+	}
+	return n, err
+}
+
+// fallbackKey is the context key of the per-call fallback.
+type fallbackKey struct{}
+
+// fallbackValue is the per-call fallback, which is nil if the fallbacks
+// are disabled for the call.
+type fallbackValue struct {
+	fb Fallback
+}
+
+// WithFallback returns a context with the fallback for the calls made with
+// the context, it takes precedence over Service.Fallback and applies to
+// any method. A nil fallback disables the fallbacks for the calls, e.g.
+// the health probes of the microservice.
+func WithFallback(ctx context.Context, fb Fallback) context.Context {
+	return context.WithValue(ctx, fallbackKey{}, fallbackValue{fb: fb})
+}
+
+// fallback returns the fallback of the call. The Service.Fallback only
+// applies to the safe methods GET and HEAD, so that a failed call that
+// alters state is never reported as successful.
+func (s *Service) fallback(ctx context.Context, method string) Fallback {
+	if v, ok := ctx.Value(fallbackKey{}).(fallbackValue); ok {
+		return v.fb
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return s.Fallback
+	}
+	return nil
+}
+
+// withFallback records the body of a successful response or replaces a
+// failed call with the response of the fallback. The fallback response
+// has the status 200 OK and the FallbackHeader set to the reason.
+func (s *Service) withFallback(ctx context.Context, fb Fallback, method string, URL url.URL, res *http.Response, e dutil.Error) (*http.Response, dutil.Error) {
+	call := FallbackCall{
+		Service: s.Name,
+		Method:  method,
+		URL:     URL,
+	}
+	switch {
+	case e != nil:
+		errs := dutil.Inst(e).Errors
+		if _, ok := errs["circuit"]; ok {
+			call.Reason = FallbackCircuit
+		} else if _, ok := errs["request"]; ok && ctx.Err() == nil {
+			call.Reason = FallbackTransport
+		} else {
+			// a cancelled call or an error creating the request is not a
+			// failure of the microservice
+			return nil, e
+		}
+		call.Error = e
+	case res.StatusCode >= 500:
+		call.Reason = FallbackStatus
+		call.StatusCode = res.StatusCode
+	default:
+		if r, ok := fb.(FallbackRecorder); ok && res.StatusCode >= 200 && res.StatusCode < 300 {
+			max := s.DecodeOptions.MaxBodySize
+			if max <= 0 {
+				max = maxRecordSize
+			}
+			res.Body = &recordedBody{
+				ReadCloser: res.Body,
+				max:        max,
+				record: func(xb []byte) {
+					r.Record(ctx, call.Method, call.URL, xb)
+				},
+			}
+		}
+		return res, nil
+	}
+
+	xb, ok := fb.Fallback(ctx, call)
+	if !ok {
+		return res, e
+	}
+	var req *http.Request
+	if res != nil {
+		req = res.Request
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+	} else {
+		req, _ = http.NewRequestWithContext(ctx, method, URL.String(), nil)
+	}
+	s.logf("- %s-service -> [%s %s] <- fallback (%s)", s.Name, call.Method, call.URL.String(), call.Reason)
+	if s.OnFallback != nil {
+		s.OnFallback(call)
+	}
+
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	header.Set("Content-Length", strconv.Itoa(len(xb)))
+	header.Set(FallbackHeader, call.Reason)
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(xb)),
+		ContentLength: int64(len(xb)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,306 @@
+package msp
+
+import (
+	"context"
+	"github.com/johannesscr/micro/microtest"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestService_Fallback(t *testing.T) {
+	circuit := func(http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return nil, ErrCircuitOpen
+		})
+	}
+	type E struct {
+		status int
+		body   string
+		reason string
+	}
+	tt := []struct {
+		name     string
+		config   Config
+		method   string
+		ctx      func(context.Context) context.Context
+		closed   bool
+		response microtest.Response
+		E        E
+	}{
+		{
+			name:     "no fallback on success",
+			config:   Config{Fallback: StaticFallback(`{"data":"static"}`)},
+			response: microtest.Response{Status: 200, Body: `{"data":"live"}`},
+			E:        E{status: 200, body: `{"data":"live"}`},
+		},
+		{
+			name:     "no fallback on 4xx",
+			config:   Config{Fallback: StaticFallback(`{"data":"static"}`)},
+			response: microtest.Response{Status: 404, Body: `{}`},
+			E:        E{status: 404, body: `{}`},
+		},
+		{
+			name:     "static on 5xx",
+			config:   Config{Fallback: StaticFallback(`{"data":"static"}`)},
+			response: microtest.Response{Status: 503, Body: `{}`},
+			E:        E{status: 200, body: `{"data":"static"}`, reason: FallbackStatus},
+		},
+		{
+			name:   "static on transport error",
+			config: Config{Fallback: StaticFallback(`{"data":"static"}`)},
+			closed: true,
+			E:      E{status: 200, body: `{"data":"static"}`, reason: FallbackTransport},
+		},
+		{
+			name: "static on open circuit",
+			config: Config{
+				Fallback:     StaticFallback(`{"data":"static"}`),
+				Interceptors: []Interceptor{circuit},
+			},
+			E: E{status: 200, body: `{"data":"static"}`, reason: FallbackCircuit},
+		},
+		{
+			name: "func",
+			config: Config{Fallback: FallbackFunc(func(_ context.Context, call FallbackCall) ([]byte, bool) {
+				return []byte(`{"data":"` + call.Method + `"}`), true
+			})},
+			response: microtest.Response{Status: 500, Body: `{}`},
+			E:        E{status: 200, body: `{"data":"GET"}`, reason: FallbackStatus},
+		},
+		{
+			name: "func without a body",
+			config: Config{Fallback: FallbackFunc(func(context.Context, FallbackCall) ([]byte, bool) {
+				return nil, false
+			})},
+			response: microtest.Response{Status: 500, Body: `{"errors":{"db":["down"]}}`},
+			E:        E{status: 500, body: `{"errors":{"db":["down"]}}`},
+		},
+		{
+			name:   "per call takes precedence",
+			config: Config{Fallback: StaticFallback(`{"data":"service"}`)},
+			ctx: func(ctx context.Context) context.Context {
+				return WithFallback(ctx, StaticFallback(`{"data":"call"}`))
+			},
+			response: microtest.Response{Status: 502, Body: `{}`},
+			E:        E{status: 200, body: `{"data":"call"}`, reason: FallbackStatus},
+		},
+		{
+			name: "per call only",
+			ctx: func(ctx context.Context) context.Context {
+				return WithFallback(ctx, StaticFallback(`{"data":"call"}`))
+			},
+			response: microtest.Response{Status: 502, Body: `{}`},
+			E:        E{status: 200, body: `{"data":"call"}`, reason: FallbackStatus},
+		},
+		{
+			name:   "disabled per call",
+			config: Config{Fallback: StaticFallback(`{"data":"service"}`)},
+			ctx: func(ctx context.Context) context.Context {
+				return WithFallback(ctx, nil)
+			},
+			response: microtest.Response{Status: 503, Body: `{}`},
+			E:        E{status: 503, body: `{}`},
+		},
+		{
+			name:     "no service fallback for POST",
+			config:   Config{Fallback: StaticFallback(`{"data":"service"}`)},
+			method:   "POST",
+			response: microtest.Response{Status: 503, Body: `{}`},
+			E:        E{status: 503, body: `{}`},
+		},
+		{
+			name:   "per call for POST",
+			config: Config{Fallback: StaticFallback(`{"data":"service"}`)},
+			method: "POST",
+			ctx: func(ctx context.Context) context.Context {
+				return WithFallback(ctx, StaticFallback(`{"data":"call"}`))
+			},
+			response: microtest.Response{Status: 503, Body: `{}`},
+			E:        E{status: 200, body: `{"data":"call"}`, reason: FallbackStatus},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls []FallbackCall
+			tc.config.Name = "micro"
+			tc.config.OnFallback = func(call FallbackCall) {
+				calls = append(calls, call)
+			}
+			s := NewService(tc.config)
+			ms := microtest.MockServer(s)
+			ms.Append(&microtest.Exchange{Response: tc.response})
+			if tc.closed {
+				ms.Server.Close()
+			} else {
+				defer ms.Server.Close()
+			}
+
+			ctx := context.Background()
+			if tc.ctx != nil {
+				ctx = tc.ctx(ctx)
+			}
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			res, e := s.DoRequestContext(ctx, method, s.URL, nil, nil, nil)
+			if e != nil {
+				t.Fatalf("unexpected error: %v", e)
+			}
+			xb, _ := io.ReadAll(res.Body)
+			_ = res.Body.Close()
+			if res.StatusCode != tc.E.status {
+				t.Errorf("expected %d got %d", tc.E.status, res.StatusCode)
+			}
+			if string(xb) != tc.E.body {
+				t.Errorf("expected '%v' got '%v'", tc.E.body, string(xb))
+			}
+			if reason := res.Header.Get(FallbackHeader); reason != tc.E.reason {
+				t.Errorf("expected '%v' got '%v'", tc.E.reason, reason)
+			}
+			if tc.E.reason == "" {
+				if len(calls) != 0 {
+					t.Errorf("expected no fallback got '%+v'", calls)
+				}
+				return
+			}
+			if len(calls) != 1 || calls[0].Reason != tc.E.reason || calls[0].Service != "micro" {
+				t.Errorf("expected a %v fallback got '%+v'", tc.E.reason, calls)
+			}
+		})
+	}
+}
+
+func TestLastKnownGood(t *testing.T) {
+	s := NewService(Config{Name: "micro", Fallback: NewLastKnownGood()})
+	ms := microtest.MockServer(s)
+	defer ms.Server.Close()
+
+	do := func(path string) (*http.Response, string) {
+		URL := s.URL
+		URL.Path = path
+		res, e := s.DoRequest("GET", URL, nil, nil, nil)
+		if e != nil {
+			t.Fatalf("unexpected error: %v", e)
+		}
+		xb, _ := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		return res, string(xb)
+	}
+
+	ms.Append(&microtest.Exchange{Response: microtest.Response{Status: 200, Body: `{"data":"v1"}`}})
+	if _, body := do("/users"); body != `{"data":"v1"}` {
+		t.Errorf("expected '%v' got '%v'", `{"data":"v1"}`, body)
+	}
+
+	ms.Append(&microtest.Exchange{Response: microtest.Response{Status: 500, Body: `{}`}})
+	res, body := do("/users")
+	if body != `{"data":"v1"}` || res.Header.Get(FallbackHeader) != FallbackStatus {
+		t.Errorf("expected the last known good response got '%v'", body)
+	}
+
+	// no successful call to the URL is known
+	ms.Append(&microtest.Exchange{Response: microtest.Response{Status: 500, Body: `{}`}})
+	res, _ = do("/orders")
+	if res.StatusCode != 500 {
+		t.Errorf("expected %d got %d", 500, res.StatusCode)
+	}
+}
+
+func TestLastKnownGood_Discriminator(t *testing.T) {
+	type userKey struct{}
+	lkg := NewLastKnownGood()
+	lkg.Discriminator = func(ctx context.Context) string {
+		user, _ := ctx.Value(userKey{}).(string)
+		return user
+	}
+	s := NewService(Config{Name: "micro", Fallback: lkg})
+	ms := microtest.MockServer(s)
+	defer ms.Server.Close()
+
+	do := func(user string) (*http.Response, string) {
+		ctx := context.WithValue(context.Background(), userKey{}, user)
+		res, e := s.DoRequestContext(ctx, "GET", s.URL, nil, nil, nil)
+		if e != nil {
+			t.Fatalf("unexpected error: %v", e)
+		}
+		xb, _ := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		return res, string(xb)
+	}
+
+	ms.Append(&microtest.Exchange{Response: microtest.Response{Status: 200, Body: `{"data":"jane"}`}})
+	_, _ = do("jane")
+
+	// the body of one user is not served to another user
+	ms.Append(&microtest.Exchange{Response: microtest.Response{Status: 500, Body: `{}`}})
+	if res, _ := do("james"); res.StatusCode != 500 {
+		t.Errorf("expected %d got %d", 500, res.StatusCode)
+	}
+	ms.Append(&microtest.Exchange{Response: microtest.Response{Status: 500, Body: `{}`}})
+	if _, body := do("jane"); body != `{"data":"jane"}` {
+		t.Errorf("expected '%v' got '%v'", `{"data":"jane"}`, body)
+	}
+}
+
+func TestLastKnownGood_MaxBodySize(t *testing.T) {
+	lkg := NewLastKnownGood()
+	s := NewService(Config{
+		Name:          "micro",
+		Fallback:      lkg,
+		DecodeOptions: DecodeOptions{MaxBodySize: 16},
+	})
+	ms := microtest.MockServer(s)
+	defer ms.Server.Close()
+
+	tt := []struct {
+		name     string
+		body     string
+		recorded bool
+	}{
+		{name: "maximum size", body: `{"data":"small"}`, recorded: true},
+		{name: "too large", body: `{"data":"larger"}`},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			URL := s.URL
+			URL.Path = "/" + tc.name
+			ms.Append(&microtest.Exchange{Response: microtest.Response{Status: 200, Body: tc.body}})
+			res, e := s.DoRequest("GET", URL, nil, nil, nil)
+			if e != nil {
+				t.Fatalf("unexpected error: %v", e)
+			}
+			// the body is not recorded before it is read
+			if _, ok := lkg.Fallback(context.Background(), FallbackCall{Method: "GET", URL: URL}); ok {
+				t.Errorf("expected no body before the body is read")
+			}
+			xb, _ := io.ReadAll(res.Body)
+			_ = res.Body.Close()
+			if string(xb) != tc.body {
+				t.Errorf("expected '%v' got '%v'", tc.body, string(xb))
+			}
+			xb, ok := lkg.Fallback(context.Background(), FallbackCall{Method: "GET", URL: URL})
+			if ok != tc.recorded {
+				t.Errorf("expected '%v' got '%v'", tc.recorded, ok)
+			}
+			if ok && string(xb) != tc.body {
+				t.Errorf("expected '%v' got '%v'", tc.body, string(xb))
+			}
+		})
+	}
+}
+
+func TestService_FallbackCancelled(t *testing.T) {
+	s := NewService(Config{Name: "micro", Fallback: StaticFallback(`{}`)})
+	ms := microtest.MockServer(s)
+	defer ms.Server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, e := s.DoRequestContext(ctx, "GET", s.URL, nil, nil, nil)
+	if e == nil {
+		t.Errorf("expected an error got nil")
+	}
+}
@@ -0,0 +1,152 @@
+package msp
+
+import (
+	"context"
+	"fmt"
+	"github.com/dottics/dutil"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Call is a single call made as part of a FanOut, typically a call to one
+// of the services used to compose a response.
+type Call struct {
+	// Name identifies the call in the FanOutResult and the errors.
+	Name string
+	// Optional calls do not fail the FanOut when they fail, their error is
+	// only reported in the FanOutResult.
+	Optional bool
+	// Do makes the call, it must respect the cancellation of the context.
+	Do func(ctx context.Context) (interface{}, dutil.Error)
+}
+
+// FanOutResult holds the results of the calls that succeeded and the errors
+// of the calls that failed, both keyed by the name of the call.
+type FanOutResult struct {
+	Results   map[string]interface{}
+	Errors    map[string]dutil.Error
+	Durations map[string]time.Duration
+}
+
+// Result returns the result of the call with the name as type T, false if
+// the call failed or its result is not of type T.
+func Result[T any](r FanOutResult, name string) (T, bool) {
+	v, ok := r.Results[name].(T)
+	return v, ok
+}
+
+// FanOut makes all the calls concurrently with a shared context and returns
+// once all the calls have returned. If timeout is larger than zero it is
+// the overall deadline for all the calls.
+//
+// When a required call fails the context of the remaining calls is
+// cancelled and an error aggregating the errors of all the failed calls is
+// returned. The results of the calls that did succeed are always returned.
+// A call that completes at the same moment as a required call fails may
+// observe the cancelled context, in which case it is reported with a
+// "context" error of context canceled and has no result.
+func FanOut(ctx context.Context, timeout time.Duration, calls ...Call) (FanOutResult, dutil.Error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	r := FanOutResult{
+		Results:   make(map[string]interface{}),
+		Errors:    make(map[string]dutil.Error),
+		Durations: make(map[string]time.Duration),
+	}
+	// failed reports whether a required call failed, status is the status
+	// of the first required call to fail
+	failed := false
+	status := 0
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, c := range calls {
+		wg.Add(1)
+		go func(c Call) {
+			defer wg.Done()
+			start := time.Now()
+			v, e := do(ctx, c)
+
+			mu.Lock()
+			defer mu.Unlock()
+			r.Durations[c.Name] = time.Since(start)
+			if e == nil {
+				r.Results[c.Name] = v
+				return
+			}
+			r.Errors[c.Name] = e
+			if !c.Optional && !failed {
+				failed = true
+				status = dutil.Inst(e).Status
+				if status == 0 {
+					status = 500
+				}
+				cancel()
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	if !failed {
+		return r, nil
+	}
+	return r, fanOutErr(status, r.Errors)
+}
+
+// do makes the call, converting a panic or a call that does not return
+// before the context is done into an error.
+func do(ctx context.Context, c Call) (interface{}, dutil.Error) {
+	type result struct {
+		v interface{}
+		e dutil.Error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				ch <- result{e: dutil.NewErr(500, "panic", []string{fmt.Sprint(err)})}
+			}
+		}()
+		v, e := c.Do(ctx)
+		ch <- result{v: v, e: e}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.v, r.e
+	case <-ctx.Done():
+		// prefer a result that is ready at the same time
+		select {
+		case r := <-ch:
+			return r.v, r.e
+		default:
+		}
+		return nil, dutil.NewErr(500, "context", []string{ctx.Err().Error()})
+	}
+}
+
+// fanOutErr aggregates the errors of the failed calls into a single error,
+// each error is listed under the name of its call.
+func fanOutErr(status int, errors map[string]dutil.Error) *dutil.Err {
+	e := &dutil.Err{
+		Status: status,
+		Errors: make(map[string][]string),
+	}
+	for name, err := range errors {
+		xs := make([]string, 0)
+		for key, values := range dutil.Inst(err).Errors {
+			for _, v := range values {
+				xs = append(xs, fmt.Sprintf("%s: %s", key, v))
+			}
+		}
+		sort.Strings(xs)
+		e.Errors[name] = xs
+	}
+	return e
+}
@@ -0,0 +1,206 @@
+package msp
+
+import (
+	"context"
+	"github.com/dottics/dutil"
+	"github.com/johannesscr/micro/microtest"
+	"testing"
+	"time"
+)
+
+func TestFanOut(t *testing.T) {
+	ok := func(v interface{}) func(ctx context.Context) (interface{}, dutil.Error) {
+		return func(ctx context.Context) (interface{}, dutil.Error) {
+			return v, nil
+		}
+	}
+	fail := func(status int) func(ctx context.Context) (interface{}, dutil.Error) {
+		return func(ctx context.Context) (interface{}, dutil.Error) {
+			// give the other calls time to return first
+			time.Sleep(10 * time.Millisecond)
+			return nil, dutil.NewErr(status, "request", []string{"failed"})
+		}
+	}
+	// hang ignores the context, the fan-out must not wait for it
+	hang := func(ctx context.Context) (interface{}, dutil.Error) {
+		time.Sleep(time.Second)
+		return 1, nil
+	}
+
+	type E struct {
+		results []string
+		errors  []string
+		e       string
+		status  int
+	}
+	tt := []struct {
+		name    string
+		timeout time.Duration
+		calls   []Call
+		E       E
+	}{
+		{
+			name: "all succeed",
+			calls: []Call{
+				{Name: "a", Do: ok(1)},
+				{Name: "b", Do: ok(2)},
+			},
+			E: E{results: []string{"a", "b"}},
+		},
+		{
+			name: "optional fails",
+			calls: []Call{
+				{Name: "a", Do: ok(1)},
+				{Name: "b", Optional: true, Do: fail(503)},
+			},
+			E: E{results: []string{"a"}, errors: []string{"b"}},
+		},
+		{
+			name: "required fails",
+			calls: []Call{
+				{Name: "a", Do: ok(1)},
+				{Name: "b", Do: fail(404)},
+			},
+			E: E{
+				results: []string{"a"},
+				errors:  []string{"b"},
+				e:       "map[b:[request: failed]]",
+				status:  404,
+			},
+		},
+		{
+			name: "required fails without a status",
+			calls: []Call{
+				{Name: "a", Do: ok(1)},
+				{Name: "b", Do: func(ctx context.Context) (interface{}, dutil.Error) {
+					time.Sleep(10 * time.Millisecond)
+					return nil, &dutil.Err{Errors: map[string][]string{"request": {"failed"}}}
+				}},
+			},
+			E: E{
+				results: []string{"a"},
+				errors:  []string{"b"},
+				e:       "map[b:[request: failed]]",
+				status:  500,
+			},
+		},
+		{
+			name: "required fails cancels others",
+			calls: []Call{
+				{Name: "a", Optional: true, Do: hang},
+				{Name: "b", Do: fail(400)},
+			},
+			E: E{
+				errors: []string{"a", "b"},
+				e:      "map[a:[context: context canceled] b:[request: failed]]",
+				status: 400,
+			},
+		},
+		{
+			name:    "deadline",
+			timeout: 10 * time.Millisecond,
+			calls: []Call{
+				{Name: "a", Do: ok(1)},
+				{Name: "b", Do: hang},
+			},
+			E: E{
+				results: []string{"a"},
+				errors:  []string{"b"},
+				e:       "map[b:[context: context deadline exceeded]]",
+				status:  500,
+			},
+		},
+		{
+			name: "panic",
+			calls: []Call{
+				{Name: "a", Optional: true, Do: func(ctx context.Context) (interface{}, dutil.Error) {
+					panic("boom")
+				}},
+			},
+			E: E{errors: []string{"a"}},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r, e := FanOut(context.Background(), tc.timeout, tc.calls...)
+			if len(r.Results) != len(tc.E.results) {
+				t.Errorf("expected %d results got %d", len(tc.E.results), len(r.Results))
+			}
+			for _, name := range tc.E.results {
+				if _, ok := r.Results[name]; !ok {
+					t.Errorf("expected result '%v'", name)
+				}
+			}
+			if len(r.Errors) != len(tc.E.errors) {
+				t.Errorf("expected %d errors got %d", len(tc.E.errors), len(r.Errors))
+			}
+			for _, name := range tc.E.errors {
+				if _, ok := r.Errors[name]; !ok {
+					t.Errorf("expected error '%v'", name)
+				}
+			}
+			if tc.E.e == "" {
+				if e != nil {
+					t.Errorf("unexpected error: %v", e)
+				}
+				return
+			}
+			if e == nil {
+				t.Fatalf("expected '%v' got nil", tc.E.e)
+			}
+			if e.Error() != tc.E.e {
+				t.Errorf("expected '%v' got '%v'", tc.E.e, e.Error())
+			}
+			if dutil.Inst(e).Status != tc.E.status {
+				t.Errorf("expected %d got %d", tc.E.status, dutil.Inst(e).Status)
+			}
+		})
+	}
+}
+
+func TestFanOut_services(t *testing.T) {
+	users := NewService(Config{Name: "users"})
+	mu := microtest.MockServer(users)
+	defer mu.Server.Close()
+	mu.Append(&microtest.Exchange{Response: microtest.Response{
+		Status: 200,
+		Body:   `{"data":{"user":{"name":"james"}}}`,
+	}})
+
+	get := func(s *Service) func(ctx context.Context) (interface{}, dutil.Error) {
+		return func(ctx context.Context) (interface{}, dutil.Error) {
+			resp := struct {
+				Data map[string]interface{} `json:"data"`
+			}{}
+			res, e := s.DoRequestContext(ctx, "GET", s.URL, nil, nil, nil)
+			if e != nil {
+				return nil, e
+			}
+			_, e = s.Decode(res, &resp)
+			return resp.Data, e
+		}
+	}
+
+	// the orders service is not running
+	orders := NewService(Config{Name: "orders"})
+	orders.SetURL("http", "127.0.0.1:1")
+
+	r, e := FanOut(context.Background(), time.Second,
+		Call{Name: "users", Do: get(users)},
+		Call{Name: "orders", Optional: true, Do: get(orders)},
+	)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	data, ok := Result[map[string]interface{}](r, "users")
+	if !ok {
+		t.Fatalf("expected a users result")
+	}
+	if data["user"].(map[string]interface{})["name"] != "james" {
+		t.Errorf("expected '%v' got '%v'", "james", data["user"])
+	}
+	if _, ok := r.Errors["orders"]; !ok {
+		t.Errorf("expected an orders error")
+	}
+}
@@ -0,0 +1,178 @@
+package msp
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/dottics/dutil"
+	"time"
+)
+
+// HealthStatus is the health of a microservice.
+type HealthStatus string
+
+const (
+	// HealthUp means the microservice responded as expected.
+	HealthUp HealthStatus = "up"
+	// HealthDegraded means the microservice responded as expected, however,
+	// it responded slower than the HealthConfig.DegradedLatency.
+	HealthDegraded HealthStatus = "degraded"
+	// HealthDown means the microservice could not be reached or did not
+	// respond as expected.
+	HealthDown HealthStatus = "down"
+)
+
+// Probe is the kind of health check made to a microservice.
+type Probe string
+
+const (
+	// Liveness checks that the microservice is running.
+	Liveness Probe = "liveness"
+	// Readiness checks that the microservice is ready to handle requests.
+	Readiness Probe = "readiness"
+)
+
+// HealthConfig configures the health checks of a microservice.
+type HealthConfig struct {
+	// LivenessPath is the path of the liveness endpoint, default "/".
+	LivenessPath string
+	// ReadinessPath is the path of the readiness endpoint, default the
+	// LivenessPath.
+	ReadinessPath string
+	// ExpectedStatus are the response status codes of a healthy
+	// microservice, default 200.
+	ExpectedStatus []int
+	// Timeout is the maximum duration of a health check, default 5s.
+	Timeout time.Duration
+	// DegradedLatency is the latency above which a healthy microservice is
+	// reported as degraded, zero means it is never degraded.
+	DegradedLatency time.Duration
+	// IgnoreBody does not require the body of the response to be the
+	// standard JSON envelope.
+	IgnoreBody bool
+}
+
+// withDefaults returns the config with the defaults set for the options
+// that are not set.
+func (hc HealthConfig) withDefaults() HealthConfig {
+	if hc.LivenessPath == "" {
+		hc.LivenessPath = "/"
+	}
+	if hc.ReadinessPath == "" {
+		hc.ReadinessPath = hc.LivenessPath
+	}
+	if len(hc.ExpectedStatus) == 0 {
+		hc.ExpectedStatus = []int{200}
+	}
+	if hc.Timeout <= 0 {
+		hc.Timeout = 5 * time.Second
+	}
+	return hc
+}
+
+// expected reports whether the status code is an expected status code.
+func (hc HealthConfig) expected(status int) bool {
+	for _, s := range hc.ExpectedStatus {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// HealthResult is the result of a health check of a microservice.
+type HealthResult struct {
+	Service    string        `json:"service"`
+	Probe      Probe         `json:"probe"`
+	Status     HealthStatus  `json:"status"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Latency    time.Duration `json:"latency"`
+	Error      dutil.Error   `json:"error,omitempty"`
+	CheckedAt  time.Time     `json:"checked_at"`
+}
+
+// Healthy reports whether the microservice is up or degraded.
+func (r HealthResult) Healthy() bool {
+	return r.Status == HealthUp || r.Status == HealthDegraded
+}
+
+// MarshalJSON marshals the result with the latency as a duration string.
+func (r HealthResult) MarshalJSON() ([]byte, error) {
+	type result HealthResult
+	return json.Marshal(struct {
+		result
+		Latency string `json:"latency"`
+	}{
+		result:  result(r),
+		Latency: r.Latency.String(),
+	})
+}
+
+// Liveness checks that the microservice is running.
+func (s *Service) Liveness(ctx context.Context) HealthResult {
+	return s.Probe(ctx, Liveness)
+}
+
+// Readiness checks that the microservice is ready to handle requests.
+func (s *Service) Readiness(ctx context.Context) HealthResult {
+	return s.Probe(ctx, Readiness)
+}
+
+// Probe makes the health check to the endpoint of the probe and measures
+// the latency of the microservice.
+func (s *Service) Probe(ctx context.Context, probe Probe) HealthResult {
+	hc := s.Health.withDefaults()
+	URL := s.URL
+	URL.Path = hc.LivenessPath
+	if probe == Readiness {
+		URL.Path = hc.ReadinessPath
+	}
+
+	r := HealthResult{
+		Service:   s.Name,
+		Probe:     probe,
+		Status:    HealthDown,
+		CheckedAt: time.Now(),
+	}
+	ctx, cancel := context.WithTimeout(ctx, hc.Timeout)
+	defer cancel()
+	// a fallback response would report a failed microservice as up
+	ctx = WithFallback(ctx, nil)
+
+	start := time.Now()
+	res, e := s.DoRequestContext(ctx, "GET", URL, nil, nil, nil)
+	if e != nil {
+		r.Latency = time.Since(start)
+		r.Error = e
+		return r
+	}
+	xb, e := s.Decode(res, nil)
+	r.Latency = time.Since(start)
+	r.StatusCode = res.StatusCode
+	if e != nil {
+		r.Error = e
+		return r
+	}
+
+	if !hc.expected(res.StatusCode) {
+		r.Error = ResponseError(res, xb)
+		return r
+	}
+	if !hc.IgnoreBody {
+		resp := struct {
+			Message string              `json:"message"`
+			Data    interface{}         `json:"data"`
+			Errors  map[string][]string `json:"errors"`
+		}{}
+		err := unmarshal(xb, &resp, s.DecodeOptions)
+		if err != nil {
+			r.Error = unmarshalErr(err, xb)
+			return r
+		}
+	}
+
+	r.Status = HealthUp
+	if hc.DegradedLatency > 0 && r.Latency > hc.DegradedLatency {
+		r.Status = HealthDegraded
+	}
+	return r
+}
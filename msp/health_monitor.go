@@ -0,0 +1,254 @@
+package msp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// HealthTransition is a change of the health status of a dependency.
+type HealthTransition struct {
+	Service string
+	// From is the previous status, it is empty for the first status of the
+	// dependency.
+	From   HealthStatus
+	To     HealthStatus
+	Result HealthResult
+}
+
+// MonitorConfig configures a HealthMonitor.
+type MonitorConfig struct {
+	// Interval is the time between health checks, default 30s.
+	Interval time.Duration
+	// History is the number of recent results kept per dependency,
+	// default 10.
+	History int
+	// Threshold is the number of consecutive results with a new status
+	// before the status transitions, to debounce a flapping dependency,
+	// default 2.
+	Threshold int
+	// Probe is the probe made to the dependencies, default Readiness.
+	Probe Probe
+}
+
+// withDefaults returns the config with the defaults set for the options
+// that are not set.
+func (c MonitorConfig) withDefaults() MonitorConfig {
+	if c.Interval <= 0 {
+		c.Interval = 30 * time.Second
+	}
+	if c.History <= 0 {
+		c.History = 10
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = 2
+	}
+	if c.Probe == "" {
+		c.Probe = Readiness
+	}
+	return c
+}
+
+// monitorState is the monitored state of a dependency.
+type monitorState struct {
+	status  HealthStatus
+	pending HealthStatus
+	count   int
+	history []HealthResult
+}
+
+// HealthMonitor periodically checks the health of the dependencies in a
+// HealthRegistry in the background, keeps a history of the recent results
+// and notifies the callbacks and subscribers when the status of a
+// dependency transitions between up, degraded and down.
+type HealthMonitor struct {
+	registry *HealthRegistry
+	config   MonitorConfig
+
+	mu          sync.RWMutex
+	states      map[string]*monitorState
+	callbacks   []func(HealthTransition)
+	subscribers []chan HealthTransition
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+// NewHealthMonitor creates a HealthMonitor of the dependencies in the
+// registry.
+func NewHealthMonitor(registry *HealthRegistry, config MonitorConfig) *HealthMonitor {
+	return &HealthMonitor{
+		registry: registry,
+		config:   config.withDefaults(),
+		states:   make(map[string]*monitorState),
+	}
+}
+
+// OnTransition registers a callback that is called for each transition,
+// callbacks are called in order on the monitor goroutine.
+func (m *HealthMonitor) OnTransition(f func(HealthTransition)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.callbacks = append(m.callbacks, f)
+}
+
+// Subscribe returns a channel on which the transitions are published. A
+// transition is dropped if the buffer of the channel is full. The channel
+// is closed when the monitor is stopped.
+func (m *HealthMonitor) Subscribe(buffer int) <-chan HealthTransition {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch := make(chan HealthTransition, buffer)
+	m.subscribers = append(m.subscribers, ch)
+	return ch
+}
+
+// Start checks the health of the dependencies immediately and then on each
+// interval until Stop is called or the context is done.
+func (m *HealthMonitor) Start(ctx context.Context) {
+	m.mu.Lock()
+	if m.cancel != nil {
+		m.mu.Unlock()
+		return
+	}
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.done = make(chan struct{})
+	m.mu.Unlock()
+
+	go func() {
+		defer close(m.done)
+		t := time.NewTicker(m.config.Interval)
+		defer t.Stop()
+		for {
+			m.Check(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// Stop stops the monitor, waits for a running check to complete and
+// closes the subscribed channels.
+func (m *HealthMonitor) Stop() {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ch := range m.subscribers {
+		close(ch)
+	}
+	m.subscribers = nil
+	m.cancel = nil
+}
+
+// Check checks the health of the dependencies once and records the results.
+func (m *HealthMonitor) Check(ctx context.Context) {
+	report := m.registry.Check(ctx, m.config.Probe)
+	if ctx.Err() != nil {
+		// the results of a cancelled check are not a reflection of the
+		// health of the dependencies
+		return
+	}
+
+	var transitions []HealthTransition
+	m.mu.Lock()
+	for name, d := range report.Dependencies {
+		if t, ok := m.record(name, d.HealthResult); ok {
+			transitions = append(transitions, t)
+		}
+	}
+	// forget the dependencies that have been deregistered
+	for name := range m.states {
+		if _, ok := report.Dependencies[name]; !ok {
+			delete(m.states, name)
+		}
+	}
+	callbacks := append(([]func(HealthTransition))(nil), m.callbacks...)
+	m.mu.Unlock()
+
+	for _, t := range transitions {
+		for _, f := range callbacks {
+			f(t)
+		}
+		m.publish(t)
+	}
+}
+
+// record adds the result to the history of the dependency and returns the
+// transition if the status of the dependency changed. The caller must hold
+// the lock.
+func (m *HealthMonitor) record(name string, r HealthResult) (HealthTransition, bool) {
+	s, ok := m.states[name]
+	if !ok {
+		s = &monitorState{}
+		m.states[name] = s
+	}
+	s.history = append(s.history, r)
+	if len(s.history) > m.config.History {
+		s.history = s.history[len(s.history)-m.config.History:]
+	}
+
+	if r.Status == s.status {
+		s.pending, s.count = "", 0
+		return HealthTransition{}, false
+	}
+	if r.Status != s.pending {
+		s.pending, s.count = r.Status, 0
+	}
+	s.count++
+	// the first status is known immediately
+	if s.status != "" && s.count < m.config.Threshold {
+		return HealthTransition{}, false
+	}
+
+	t := HealthTransition{
+		Service: name,
+		From:    s.status,
+		To:      r.Status,
+		Result:  r,
+	}
+	s.status, s.pending, s.count = r.Status, "", 0
+	return t, true
+}
+
+// publish sends the transition to the subscribers without blocking.
+func (m *HealthMonitor) publish(t HealthTransition) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, ch := range m.subscribers {
+		select {
+		case ch <- t:
+		default:
+		}
+	}
+}
+
+// Status returns the current status of the dependency, it is empty if the
+// dependency has not been checked.
+func (m *HealthMonitor) Status(name string) HealthStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if s, ok := m.states[name]; ok {
+		return s.status
+	}
+	return ""
+}
+
+// History returns the recent results of the dependency, oldest first.
+func (m *HealthMonitor) History(name string) []HealthResult {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if s, ok := m.states[name]; ok {
+		return append([]HealthResult(nil), s.history...)
+	}
+	return nil
+}
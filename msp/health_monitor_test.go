@@ -0,0 +1,95 @@
+package msp
+
+import (
+	"context"
+	"github.com/johannesscr/micro/microtest"
+	"testing"
+	"time"
+)
+
+func TestHealthMonitor_Check(t *testing.T) {
+	s := NewService(Config{Name: "users"})
+	ms := microtest.MockServer(s)
+	defer ms.Server.Close()
+
+	r := NewHealthRegistry()
+	r.Register("users", s, true)
+	m := NewHealthMonitor(r, MonitorConfig{History: 3, Threshold: 2})
+	var transitions []HealthTransition
+	m.OnTransition(func(t HealthTransition) {
+		transitions = append(transitions, t)
+	})
+
+	// a single down result is debounced, two consecutive results transition
+	statuses := []int{200, 503, 200, 503, 503, 200, 200}
+	for _, status := range statuses {
+		ms.Append(&microtest.Exchange{Response: microtest.Response{Status: status, Body: `{}`}})
+		m.Check(context.Background())
+	}
+
+	E := []HealthTransition{
+		{Service: "users", From: "", To: HealthUp},
+		{Service: "users", From: HealthUp, To: HealthDown},
+		{Service: "users", From: HealthDown, To: HealthUp},
+	}
+	if len(transitions) != len(E) {
+		t.Fatalf("expected %d transitions got %d: %+v", len(E), len(transitions), transitions)
+	}
+	for i, tr := range transitions {
+		if tr.Service != E[i].Service || tr.From != E[i].From || tr.To != E[i].To {
+			t.Errorf("expected '%v -> %v' got '%v -> %v'", E[i].From, E[i].To, tr.From, tr.To)
+		}
+		if tr.Result.Status != tr.To {
+			t.Errorf("expected '%v' got '%v'", tr.To, tr.Result.Status)
+		}
+	}
+	if status := m.Status("users"); status != HealthUp {
+		t.Errorf("expected '%v' got '%v'", HealthUp, status)
+	}
+	history := m.History("users")
+	if len(history) != 3 {
+		t.Fatalf("expected 3 got %d", len(history))
+	}
+	if history[0].StatusCode != 503 || history[2].StatusCode != 200 {
+		t.Errorf("expected the most recent results got '%+v'", history)
+	}
+
+	// deregistered dependencies are forgotten
+	r.Deregister("users")
+	m.Check(context.Background())
+	if status := m.Status("users"); status != "" {
+		t.Errorf("expected '' got '%v'", status)
+	}
+	if history := m.History("users"); history != nil {
+		t.Errorf("expected nil got '%v'", history)
+	}
+}
+
+func TestHealthMonitor_Start(t *testing.T) {
+	s := NewService(Config{Name: "users"})
+	ms := microtest.MockServer(s)
+	defer ms.Server.Close()
+	ms.Append(&microtest.Exchange{Response: microtest.Response{Status: 200, Body: `{}`}})
+
+	r := NewHealthRegistry()
+	r.Register("users", s, true)
+	m := NewHealthMonitor(r, MonitorConfig{Interval: time.Hour})
+	ch := m.Subscribe(1)
+	m.Start(context.Background())
+
+	select {
+	case tr := <-ch:
+		if tr.From != "" || tr.To != HealthUp {
+			t.Errorf("expected ' -> up' got '%v -> %v'", tr.From, tr.To)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a transition")
+	}
+
+	m.Stop()
+	if _, ok := <-ch; ok {
+		t.Errorf("expected the channel to be closed")
+	}
+	// stopping a stopped monitor is a no-op
+	m.Stop()
+}
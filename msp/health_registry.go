@@ -0,0 +1,188 @@
+package msp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Dependency is a microservice that a service depends on.
+type Dependency struct {
+	Service *Service
+	// Critical dependencies make the service unhealthy when they are down,
+	// optional dependencies only degrade the service.
+	Critical bool
+}
+
+// DependencyHealth is the health of a dependency.
+type DependencyHealth struct {
+	HealthResult
+	Critical bool
+}
+
+// MarshalJSON marshals the health result of the dependency and whether it
+// is critical.
+func (d DependencyHealth) MarshalJSON() ([]byte, error) {
+	type result HealthResult
+	return json.Marshal(struct {
+		result
+		Latency  string `json:"latency"`
+		Critical bool   `json:"critical"`
+	}{
+		result:   result(d.HealthResult),
+		Latency:  d.Latency.String(),
+		Critical: d.Critical,
+	})
+}
+
+// HealthReport is the aggregated health of all the dependencies.
+type HealthReport struct {
+	Status       HealthStatus                `json:"status"`
+	Dependencies map[string]DependencyHealth `json:"dependencies"`
+	CheckedAt    time.Time                   `json:"checked_at"`
+}
+
+// HealthRegistry holds the named dependencies of a service and checks
+// their health concurrently.
+type HealthRegistry struct {
+	// Timeout is the maximum duration of the health check of a single
+	// dependency, default 5s.
+	Timeout time.Duration
+
+	mu           sync.RWMutex
+	dependencies map[string]Dependency
+}
+
+// NewHealthRegistry creates an empty HealthRegistry.
+func NewHealthRegistry() *HealthRegistry {
+	return &HealthRegistry{
+		Timeout:      5 * time.Second,
+		dependencies: make(map[string]Dependency),
+	}
+}
+
+// Register adds the service as a dependency with the name, a dependency
+// with the same name is replaced.
+func (r *HealthRegistry) Register(name string, s *Service, critical bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dependencies[name] = Dependency{Service: s, Critical: critical}
+}
+
+// Deregister removes the dependency with the name.
+func (r *HealthRegistry) Deregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.dependencies, name)
+}
+
+// Names returns the sorted names of the dependencies.
+func (r *HealthRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.dependencies))
+	for name := range r.dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check makes the probe to all the dependencies concurrently and aggregates
+// the results. The report is down when a critical dependency is down,
+// degraded when an optional dependency is down or any dependency is
+// degraded, otherwise up.
+func (r *HealthRegistry) Check(ctx context.Context, probe Probe) HealthReport {
+	r.mu.RLock()
+	dependencies := make(map[string]Dependency, len(r.dependencies))
+	for name, d := range r.dependencies {
+		dependencies[name] = d
+	}
+	r.mu.RUnlock()
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	report := HealthReport{
+		Status:       HealthUp,
+		Dependencies: make(map[string]DependencyHealth, len(dependencies)),
+		CheckedAt:    time.Now(),
+	}
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for name, d := range dependencies {
+		wg.Add(1)
+		go func(name string, d Dependency) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			result := d.Service.Probe(ctx, probe)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Dependencies[name] = DependencyHealth{
+				HealthResult: result,
+				Critical:     d.Critical,
+			}
+		}(name, d)
+	}
+	wg.Wait()
+
+	for _, d := range report.Dependencies {
+		switch {
+		case d.Status == HealthDown && d.Critical:
+			report.Status = HealthDown
+		case d.Status != HealthUp && report.Status == HealthUp:
+			report.Status = HealthDegraded
+		}
+	}
+	return report
+}
+
+// ServeHTTP responds with the aggregated health report of the dependencies
+// in the standard envelope. The readiness probe is made unless the query
+// param probe=liveness is given. The response status is 503 Service
+// Unavailable when the report is down, otherwise 200 OK, which makes the
+// handler suitable for Kubernetes probes.
+func (r *HealthRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	probe := Readiness
+	if Probe(req.URL.Query().Get("probe")) == Liveness {
+		probe = Liveness
+	}
+	report := r.Check(req.Context(), probe)
+
+	status := http.StatusOK
+	message := "healthy"
+	switch report.Status {
+	case HealthDegraded:
+		message = "degraded"
+	case HealthDown:
+		status = http.StatusServiceUnavailable
+		message = "unhealthy"
+	}
+
+	resp := struct {
+		Message string              `json:"message"`
+		Data    HealthReport        `json:"data"`
+		Errors  map[string][]string `json:"errors"`
+	}{
+		Message: message,
+		Data:    report,
+		Errors:  make(map[string][]string),
+	}
+	for name, d := range report.Dependencies {
+		if d.Error != nil {
+			resp.Errors[name] = []string{d.Error.Error()}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
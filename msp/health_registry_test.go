@@ -0,0 +1,109 @@
+package msp
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/johannesscr/micro/microtest"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthRegistry(t *testing.T) {
+	up := func() *microtest.Exchange {
+		return &microtest.Exchange{Response: microtest.Response{Status: 200, Body: `{}`}}
+	}
+	down := func() *microtest.Exchange {
+		return &microtest.Exchange{Response: microtest.Response{Status: 503, Body: `{"errors":{"db":["down"]}}`}}
+	}
+	type E struct {
+		status HealthStatus
+		code   int
+	}
+	tt := []struct {
+		name   string
+		users  *microtest.Exchange
+		orders *microtest.Exchange
+		E      E
+	}{
+		{name: "all up", users: up(), orders: up(), E: E{status: HealthUp, code: 200}},
+		{name: "optional down", users: up(), orders: down(), E: E{status: HealthDegraded, code: 200}},
+		{name: "critical down", users: down(), orders: up(), E: E{status: HealthDown, code: 503}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			users := NewService(Config{Name: "users"})
+			mu := microtest.MockServer(users)
+			defer mu.Server.Close()
+			mu.Append(tc.users)
+			orders := NewService(Config{Name: "orders"})
+			mo := microtest.MockServer(orders)
+			defer mo.Server.Close()
+			mo.Append(tc.orders)
+
+			r := NewHealthRegistry()
+			r.Register("users", users, true)
+			r.Register("orders", orders, false)
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+			res, xb := microtest.ReadRecorder(rec)
+			if res.StatusCode != tc.E.code {
+				t.Errorf("expected %d got %d", tc.E.code, res.StatusCode)
+			}
+			resp := struct {
+				Message string `json:"message"`
+				Data    struct {
+					Status       HealthStatus `json:"status"`
+					Dependencies map[string]struct {
+						Status   HealthStatus `json:"status"`
+						Critical bool         `json:"critical"`
+						Latency  string       `json:"latency"`
+					} `json:"dependencies"`
+				} `json:"data"`
+				Errors map[string][]string `json:"errors"`
+			}{}
+			if err := json.Unmarshal(xb, &resp); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.Data.Status != tc.E.status {
+				t.Errorf("expected '%v' got '%v'", tc.E.status, resp.Data.Status)
+			}
+			if len(resp.Data.Dependencies) != 2 {
+				t.Fatalf("expected 2 got %d", len(resp.Data.Dependencies))
+			}
+			if !resp.Data.Dependencies["users"].Critical || resp.Data.Dependencies["orders"].Critical {
+				t.Errorf("expected users to be critical and orders optional got '%+v'", resp.Data.Dependencies)
+			}
+			if resp.Data.Dependencies["users"].Latency == "" {
+				t.Errorf("expected a latency")
+			}
+			if tc.E.status != HealthUp && len(resp.Errors) != 1 {
+				t.Errorf("expected 1 error got '%v'", resp.Errors)
+			}
+		})
+	}
+}
+
+func TestHealthRegistry_Check_liveness(t *testing.T) {
+	s := NewService(Config{Name: "users", Health: HealthConfig{LivenessPath: "/livez", ReadinessPath: "/readyz"}})
+	ms := microtest.MockServer(s)
+	defer ms.Server.Close()
+	ex := &microtest.Exchange{Response: microtest.Response{Status: 200, Body: `{}`}}
+	ms.Append(ex)
+
+	r := NewHealthRegistry()
+	r.Register("users", s, true)
+	report := r.Check(context.Background(), Liveness)
+	if report.Status != HealthUp {
+		t.Errorf("expected '%v' got '%v'", HealthUp, report.Status)
+	}
+	if ex.Request.URL.Path != "/livez" {
+		t.Errorf("expected '%v' got '%v'", "/livez", ex.Request.URL.Path)
+	}
+
+	r.Deregister("users")
+	if len(r.Names()) != 0 {
+		t.Errorf("expected no dependencies got '%v'", r.Names())
+	}
+}
@@ -0,0 +1,164 @@
+package msp
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/johannesscr/micro/microtest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestService_Probe(t *testing.T) {
+	type E struct {
+		status     HealthStatus
+		statusCode int
+		path       string
+		e          string
+	}
+	tt := []struct {
+		name     string
+		health   HealthConfig
+		probe    Probe
+		exchange *microtest.Exchange
+		E        E
+	}{
+		{
+			name:     "default liveness",
+			probe:    Liveness,
+			exchange: &microtest.Exchange{Response: microtest.Response{Status: 200, Body: `{"message":"alive"}`}},
+			E:        E{status: HealthUp, statusCode: 200, path: "/"},
+		},
+		{
+			name:     "readiness path",
+			health:   HealthConfig{LivenessPath: "/livez", ReadinessPath: "/readyz"},
+			probe:    Readiness,
+			exchange: &microtest.Exchange{Response: microtest.Response{Status: 200, Body: `{}`}},
+			E:        E{status: HealthUp, statusCode: 200, path: "/readyz"},
+		},
+		{
+			name:     "liveness path",
+			health:   HealthConfig{LivenessPath: "/livez", ReadinessPath: "/readyz"},
+			probe:    Liveness,
+			exchange: &microtest.Exchange{Response: microtest.Response{Status: 200, Body: `{}`}},
+			E:        E{status: HealthUp, statusCode: 200, path: "/livez"},
+		},
+		{
+			name:     "expected status",
+			health:   HealthConfig{ExpectedStatus: []int{200, 204}, IgnoreBody: true},
+			probe:    Readiness,
+			exchange: &microtest.Exchange{Response: microtest.Response{Status: 204}},
+			E:        E{status: HealthUp, statusCode: 204, path: "/"},
+		},
+		{
+			name:     "unexpected status",
+			probe:    Readiness,
+			exchange: &microtest.Exchange{Response: microtest.Response{Status: 503, Body: `{"errors":{"db":["down"]}}`}},
+			E:        E{status: HealthDown, statusCode: 503, path: "/", e: "map[db:[down]]"},
+		},
+		{
+			name:     "ignore body",
+			health:   HealthConfig{IgnoreBody: true},
+			probe:    Readiness,
+			exchange: &microtest.Exchange{Response: microtest.Response{Status: 200, Body: `ok`}},
+			E:        E{status: HealthUp, statusCode: 200, path: "/"},
+		},
+		{
+			name:   "degraded",
+			health: HealthConfig{DegradedLatency: time.Nanosecond},
+			probe:  Readiness,
+			exchange: &microtest.Exchange{Response: microtest.Response{
+				Status: 200,
+				Body:   `{}`,
+			}},
+			E: E{status: HealthDegraded, statusCode: 200, path: "/"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewService(Config{Name: "micro", Health: tc.health})
+			ms := microtest.MockServer(s)
+			defer ms.Server.Close()
+			ms.Append(tc.exchange)
+
+			r := s.Probe(context.Background(), tc.probe)
+			if r.Status != tc.E.status {
+				t.Errorf("expected '%v' got '%v'", tc.E.status, r.Status)
+			}
+			if r.StatusCode != tc.E.statusCode {
+				t.Errorf("expected %d got %d", tc.E.statusCode, r.StatusCode)
+			}
+			if r.Probe != tc.probe || r.Service != "micro" {
+				t.Errorf("expected '%v %v' got '%v %v'", tc.probe, "micro", r.Probe, r.Service)
+			}
+			if r.Latency <= 0 || r.CheckedAt.IsZero() {
+				t.Errorf("expected latency and checked at got '%v' '%v'", r.Latency, r.CheckedAt)
+			}
+			if tc.exchange.Request.URL.Path != tc.E.path {
+				t.Errorf("expected '%v' got '%v'", tc.E.path, tc.exchange.Request.URL.Path)
+			}
+			if tc.E.e == "" {
+				if r.Error != nil {
+					t.Errorf("unexpected error: %v", r.Error)
+				}
+				return
+			}
+			if r.Error == nil || r.Error.Error() != tc.E.e {
+				t.Errorf("expected '%v' got '%v'", tc.E.e, r.Error)
+			}
+		})
+	}
+}
+
+func TestService_Probe_unreachable(t *testing.T) {
+	s := NewService(Config{Name: "micro", Health: HealthConfig{Timeout: time.Second}})
+	s.SetURL("http", "127.0.0.1:1")
+	r := s.Liveness(context.Background())
+	if r.Status != HealthDown {
+		t.Errorf("expected '%v' got '%v'", HealthDown, r.Status)
+	}
+	if r.Error == nil {
+		t.Errorf("expected an error got nil")
+	}
+}
+
+func TestService_Probe_fallback(t *testing.T) {
+	// the fallback of the service does not apply to the health probes
+	s := NewService(Config{
+		Name:     "micro",
+		Health:   HealthConfig{Timeout: time.Second},
+		Fallback: StaticFallback(`{"message":"","data":{},"errors":{}}`),
+	})
+	s.SetURL("http", "127.0.0.1:1")
+	r := s.Readiness(context.Background())
+	if r.Status != HealthDown {
+		t.Errorf("expected '%v' got '%v'", HealthDown, r.Status)
+	}
+	ok, e := s.HealthCheck()
+	if ok || e == nil {
+		t.Errorf("expected '%v' got '%v' %v", false, ok, e)
+	}
+}
+
+func TestHealthResult_MarshalJSON(t *testing.T) {
+	r := HealthResult{
+		Service:    "micro",
+		Probe:      Readiness,
+		Status:     HealthUp,
+		StatusCode: 200,
+		Latency:    1500 * time.Microsecond,
+		CheckedAt:  time.Date(2023, 8, 12, 0, 0, 0, 0, time.UTC),
+	}
+	xb, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	E := `{"service":"micro","probe":"readiness","status":"up","status_code":200,"checked_at":"2023-08-12T00:00:00Z","latency":"1.5ms"}`
+	if string(xb) != E {
+		t.Errorf("expected '%v' got '%v'", E, string(xb))
+	}
+	if strings.Contains(string(xb), "error") {
+		t.Errorf("expected no error got '%v'", string(xb))
+	}
+}
@@ -0,0 +1,151 @@
+package msp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OAuth2Config is the configuration to obtain bearer tokens from an OAuth2
+// token endpoint with the client-credentials grant as defined by RFC 6749
+// https://datatracker.ietf.org/doc/html/rfc6749#section-4.4.
+type OAuth2Config struct {
+	// TokenURL is the URL of the token endpoint.
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// Params are additional parameters sent to the token endpoint, such as
+	// an audience.
+	Params url.Values
+	// ExpiryDelta is how long before a token expires that it is refreshed,
+	// default 30s, at most half the lifetime of the token.
+	ExpiryDelta time.Duration
+	// HTTPClient is the client used to request the tokens, default a client
+	// with a 30s timeout, or the TLS configuration of the service if it is
+	// configured with Config.OAuth2.
+	HTTPClient *http.Client
+}
+
+// ClientCredentials is a CredentialProvider of bearer tokens obtained with
+// the OAuth2 client-credentials grant. A token is cached until shortly
+// before it expires, concurrent requests wait for a single refresh.
+type ClientCredentials struct {
+	config OAuth2Config
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewClientCredentials creates a ClientCredentials provider for the config.
+func NewClientCredentials(config OAuth2Config) *ClientCredentials {
+	if config.ExpiryDelta <= 0 {
+		config.ExpiryDelta = 30 * time.Second
+	}
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &ClientCredentials{
+		config: config,
+		client: client,
+	}
+}
+
+// Credential returns the Authorization header value of the cached token,
+// obtaining a new token if there is none or the token is about to expire.
+func (c *ClientCredentials) Credential(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && (c.expires.IsZero() || time.Now().Before(c.expires)) {
+		return "Bearer " + c.token, nil
+	}
+	err := c.refresh(ctx)
+	if err != nil {
+		return "", err
+	}
+	return "Bearer " + c.token, nil
+}
+
+// Invalidate discards the cached token so that the next request obtains a
+// new token.
+func (c *ClientCredentials) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = ""
+	c.expires = time.Time{}
+}
+
+// refresh obtains a new token from the token endpoint, the caller must hold
+// the lock.
+func (c *ClientCredentials) refresh(ctx context.Context) error {
+	form := url.Values{}
+	for key, values := range c.config.Params {
+		form[key] = append([]string(nil), values...)
+	}
+	form.Set("grant_type", "client_credentials")
+	if len(c.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.config.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	xb, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	token := struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	err = json.Unmarshal(xb, &token)
+	if err != nil {
+		return fmt.Errorf("token endpoint responded %d: %s", res.StatusCode, excerpt(xb))
+	}
+	if res.StatusCode != http.StatusOK || token.Error != "" {
+		return fmt.Errorf("token endpoint responded %d: %s %s", res.StatusCode, token.Error, token.ErrorDescription)
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("token endpoint responded without an access token")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return fmt.Errorf("unsupported token type %s", token.TokenType)
+	}
+
+	c.token = token.AccessToken
+	c.expires = time.Time{}
+	if token.ExpiresIn > 0 {
+		lifetime := time.Duration(token.ExpiresIn) * time.Second
+		// a short-lived token is used for at least half its lifetime, so
+		// that it is not refreshed on every request
+		delta := c.config.ExpiryDelta
+		if delta > lifetime/2 {
+			delta = lifetime / 2
+		}
+		c.expires = time.Now().Add(lifetime - delta)
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package msp
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"github.com/johannesscr/micro/microtest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tokenServer is a stand-in OAuth2 token endpoint that issues a new token
+// on each request.
+func tokenServer(t *testing.T, expiresIn int) (*httptest.Server, *int32) {
+	var issued int32
+	ts := httptest.NewServer(tokenHandler(t, expiresIn, &issued))
+	t.Cleanup(ts.Close)
+	return ts, &issued
+}
+
+// tokenHandler is the handler of the token endpoint which counts the
+// issued tokens.
+func tokenHandler(t *testing.T, expiresIn int, issued *int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "client" || secret != "secret" {
+			w.WriteHeader(401)
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		if r.FormValue("grant_type") != "client_credentials" {
+			t.Errorf("expected '%v' got '%v'", "client_credentials", r.FormValue("grant_type"))
+		}
+		if r.FormValue("scope") != "read write" {
+			t.Errorf("expected '%v' got '%v'", "read write", r.FormValue("scope"))
+		}
+		n := atomic.AddInt32(issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":%d}`, n, expiresIn)
+	})
+}
+
+func TestClientCredentials(t *testing.T) {
+	ts, issued := tokenServer(t, 3600)
+	c := NewClientCredentials(OAuth2Config{
+		TokenURL:     ts.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Scopes:       []string{"read", "write"},
+	})
+
+	// concurrent requests share a single token
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.Credential(context.Background())
+			if err != nil || v != "Bearer token-1" {
+				t.Errorf("expected '%v' got '%v' %v", "Bearer token-1", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if atomic.LoadInt32(issued) != 1 {
+		t.Errorf("expected 1 got %d", atomic.LoadInt32(issued))
+	}
+
+	c.Invalidate()
+	v, _ := c.Credential(context.Background())
+	if v != "Bearer token-2" {
+		t.Errorf("expected '%v' got '%v'", "Bearer token-2", v)
+	}
+}
+
+func TestClientCredentials_expiry(t *testing.T) {
+	ts, issued := tokenServer(t, 1)
+	c := NewClientCredentials(OAuth2Config{
+		TokenURL:     ts.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Scopes:       []string{"read", "write"},
+		ExpiryDelta:  950 * time.Millisecond,
+	})
+	// the delta is at most half the lifetime of the token
+	_, _ = c.Credential(context.Background())
+	v, _ := c.Credential(context.Background())
+	if v != "Bearer token-1" {
+		t.Errorf("expected '%v' got '%v'", "Bearer token-1", v)
+	}
+	time.Sleep(550 * time.Millisecond)
+	v, _ = c.Credential(context.Background())
+	if v != "Bearer token-2" {
+		t.Errorf("expected '%v' got '%v'", "Bearer token-2", v)
+	}
+	if atomic.LoadInt32(issued) != 2 {
+		t.Errorf("expected 2 got %d", atomic.LoadInt32(issued))
+	}
+}
+
+func TestClientCredentials_shortLived(t *testing.T) {
+	// a token that expires within the default delta is not refreshed on
+	// each request
+	ts, issued := tokenServer(t, 10)
+	c := NewClientCredentials(OAuth2Config{
+		TokenURL:     ts.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Scopes:       []string{"read", "write"},
+	})
+	for i := 0; i < 5; i++ {
+		v, err := c.Credential(context.Background())
+		if err != nil || v != "Bearer token-1" {
+			t.Errorf("expected '%v' got '%v' %v", "Bearer token-1", v, err)
+		}
+	}
+	if atomic.LoadInt32(issued) != 1 {
+		t.Errorf("expected 1 got %d", atomic.LoadInt32(issued))
+	}
+}
+
+func TestClientCredentials_TLS(t *testing.T) {
+	var issued int32
+	ts := httptest.NewTLSServer(tokenHandler(t, 3600, &issued))
+	defer ts.Close()
+	config := OAuth2Config{
+		TokenURL:     ts.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Scopes:       []string{"read", "write"},
+	}
+
+	// the certificate of the token endpoint is not trusted by default
+	_, err := NewClientCredentials(config).Credential(context.Background())
+	if err == nil {
+		t.Errorf("expected an error got nil")
+	}
+
+	// the client of the config trusts the certificate
+	c := config
+	c.HTTPClient = ts.Client()
+	v, err := NewClientCredentials(c).Credential(context.Background())
+	if err != nil || v != "Bearer token-1" {
+		t.Errorf("expected '%v' got '%v' %v", "Bearer token-1", v, err)
+	}
+
+	// the TLS configuration of the service trusts the certificate
+	t.Setenv("MICRO_TEST_TOKEN_CA_PEM", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})))
+	s := NewService(Config{
+		Name:   "micro",
+		OAuth2: &config,
+		TLS:    &TLSConfig{CAPEMEnv: "MICRO_TEST_TOKEN_CA_PEM"},
+	})
+	v, err = s.Credentials["Authorization"].Credential(context.Background())
+	if err != nil || v != "Bearer token-2" {
+		t.Errorf("expected '%v' got '%v' %v", "Bearer token-2", v, err)
+	}
+}
+
+func TestClientCredentials_error(t *testing.T) {
+	ts, _ := tokenServer(t, 3600)
+	c := NewClientCredentials(OAuth2Config{
+		TokenURL:     ts.URL,
+		ClientID:     "client",
+		ClientSecret: "wrong",
+	})
+	_, err := c.Credential(context.Background())
+	E := "token endpoint responded 401: invalid_client "
+	if err == nil || err.Error() != E {
+		t.Errorf("expected '%v' got '%v'", E, err)
+	}
+}
+
+func TestService_DoRequest_oauth2(t *testing.T) {
+	ts, issued := tokenServer(t, 3600)
+	s := NewService(Config{
+		Name: "micro",
+		OAuth2: &OAuth2Config{
+			TokenURL:     ts.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			Scopes:       []string{"read", "write"},
+		},
+	})
+	ms := microtest.MockServer(s)
+	defer ms.Server.Close()
+
+	// the token is rejected once, the request is retried with a new token
+	e1 := &microtest.Exchange{Response: microtest.Response{Status: 401}}
+	e2 := &microtest.Exchange{Response: microtest.Response{Status: 200, Body: `{}`}}
+	ms.Append(e1)
+	ms.Append(e2)
+
+	res, e := s.DoRequest("POST", s.URL, nil, nil, strings.NewReader(`{"name":"james"}`))
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if res.StatusCode != 200 {
+		t.Errorf("expected %d got %d", 200, res.StatusCode)
+	}
+	if h := e1.Request.Header.Get("Authorization"); h != "Bearer token-1" {
+		t.Errorf("expected '%v' got '%v'", "Bearer token-1", h)
+	}
+	if h := e2.Request.Header.Get("Authorization"); h != "Bearer token-2" {
+		t.Errorf("expected '%v' got '%v'", "Bearer token-2", h)
+	}
+	// the payload is sent again
+	if r, _ := e2.Last(); string(r.Body()) != `{"name":"james"}` {
+		t.Errorf("expected '%v' got '%v'", `{"name":"james"}`, string(r.Body()))
+	}
+
+	// a second rejection is returned to the caller
+	ms.Append(&microtest.Exchange{Response: microtest.Response{Status: 401}})
+	ms.Append(&microtest.Exchange{Response: microtest.Response{Status: 401}})
+	res, _ = s.DoRequest("GET", s.URL, nil, nil, nil)
+	if res.StatusCode != 401 {
+		t.Errorf("expected %d got %d", 401, res.StatusCode)
+	}
+	if atomic.LoadInt32(issued) != 3 {
+		t.Errorf("expected 3 got %d", atomic.LoadInt32(issued))
+	}
+}
+
+func TestService_DoRequest_payload(t *testing.T) {
+	ts, _ := tokenServer(t, 3600)
+	type E struct {
+		contentLength int64
+		chunked       bool
+	}
+	tt := []struct {
+		name   string
+		config Config
+		E      E
+	}{
+		{name: "streamed", config: Config{Name: "micro"}, E: E{contentLength: -1, chunked: true}},
+		{name: "signed", config: Config{Name: "micro", Signer: &HMACSigner{KeyID: "k", Secret: []byte("s")}}, E: E{contentLength: 16}},
+		{
+			name: "retried",
+			config: Config{Name: "micro", OAuth2: &OAuth2Config{
+				TokenURL:     ts.URL,
+				ClientID:     "client",
+				ClientSecret: "secret",
+				Scopes:       []string{"read", "write"},
+			}},
+			E: E{contentLength: 16},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var contentLength int64
+			var chunked bool
+			var body string
+			ss := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contentLength = r.ContentLength
+				chunked = len(r.TransferEncoding) == 1 && r.TransferEncoding[0] == "chunked"
+				xb, _ := io.ReadAll(r.Body)
+				body = string(xb)
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			defer ss.Close()
+			s := NewService(tc.config)
+			URL, _ := url.Parse(ss.URL)
+			s.SetURL(URL.Scheme, URL.Host)
+
+			// hide the type of the reader so that its length is unknown
+			payload := struct{ io.Reader }{strings.NewReader(`{"name":"james"}`)}
+			res, e := s.DoRequest("POST", s.URL, nil, nil, payload)
+			if e != nil {
+				t.Fatalf("unexpected error: %v", e)
+			}
+			_ = res.Body.Close()
+			if body != `{"name":"james"}` {
+				t.Errorf("expected '%v' got '%v'", `{"name":"james"}`, body)
+			}
+			if contentLength != tc.E.contentLength {
+				t.Errorf("expected %d got %d", tc.E.contentLength, contentLength)
+			}
+			if chunked != tc.E.chunked {
+				t.Errorf("expected '%v' got '%v'", tc.E.chunked, chunked)
+			}
+		})
+	}
+}
@@ -0,0 +1,412 @@
+package msp
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/dottics/dutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// PageMeta is the pagination information a microservice includes in the
+// data of a paged response under the "pagination" key.
+type PageMeta struct {
+	Page       int    `json:"page"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor"`
+	HasMore    *bool  `json:"has_more"`
+}
+
+// PageInfo describes a page that has been received, it is used by a
+// PageStrategy to determine the request for the next page.
+type PageInfo struct {
+	// URL is the URL the page was requested from.
+	URL url.URL
+	// Count is the number of items on the page.
+	Count int
+	// Meta is the pagination information returned with the page.
+	Meta PageMeta
+	// Header is the header of the response of the page.
+	Header http.Header
+}
+
+// Page is a single page of items received from a microservice.
+type Page[T any] struct {
+	PageInfo
+	Items []T
+}
+
+// PageStrategy defines how the pages of a list endpoint are requested.
+type PageStrategy interface {
+	// First sets the query params to request the first page.
+	First(q url.Values)
+	// Next updates the URL and query params to request the page following
+	// the page p. Next returns false when p is the last page.
+	Next(URL *url.URL, q url.Values, p PageInfo) bool
+}
+
+// PagePagination requests pages by page number and page size, the first
+// page is page 1.
+type PagePagination struct {
+	// PageParam is the query param of the page number, default "page".
+	PageParam string
+	// LimitParam is the query param of the page size, default "limit".
+	LimitParam string
+	// Limit is the page size, if zero the microservice's default is used.
+	Limit int
+}
+
+// First sets the page to 1 and the limit iff it is set.
+func (pp PagePagination) First(q url.Values) {
+	q.Set(param(pp.PageParam, "page"), "1")
+	if pp.Limit > 0 {
+		q.Set(param(pp.LimitParam, "limit"), strconv.Itoa(pp.Limit))
+	}
+}
+
+// Next sets the page number to the page following the current page.
+func (pp PagePagination) Next(_ *url.URL, q url.Values, p PageInfo) bool {
+	page, _ := strconv.Atoi(q.Get(param(pp.PageParam, "page")))
+	if page < 1 {
+		page = 1
+	}
+	if last(p, page*limit(pp.Limit, p), pp.Limit) {
+		return false
+	}
+	q.Set(param(pp.PageParam, "page"), strconv.Itoa(page+1))
+	return true
+}
+
+// OffsetPagination requests pages by the offset of the first item and the
+// page size.
+type OffsetPagination struct {
+	// OffsetParam is the query param of the offset, default "offset".
+	OffsetParam string
+	// LimitParam is the query param of the page size, default "limit".
+	LimitParam string
+	// Limit is the page size, if zero the microservice's default is used.
+	Limit int
+}
+
+// First sets the offset to 0 and the limit iff it is set.
+func (op OffsetPagination) First(q url.Values) {
+	q.Set(param(op.OffsetParam, "offset"), "0")
+	if op.Limit > 0 {
+		q.Set(param(op.LimitParam, "limit"), strconv.Itoa(op.Limit))
+	}
+}
+
+// Next moves the offset past the items of the current page.
+func (op OffsetPagination) Next(_ *url.URL, q url.Values, p PageInfo) bool {
+	offset, _ := strconv.Atoi(q.Get(param(op.OffsetParam, "offset")))
+	offset += p.Count
+	if last(p, offset, op.Limit) {
+		return false
+	}
+	q.Set(param(op.OffsetParam, "offset"), strconv.Itoa(offset))
+	return true
+}
+
+// CursorPagination requests pages with the opaque cursor the microservice
+// returns as the next_cursor of the pagination information.
+type CursorPagination struct {
+	// CursorParam is the query param of the cursor, default "cursor".
+	CursorParam string
+	// LimitParam is the query param of the page size, default "limit".
+	LimitParam string
+	// Limit is the page size, if zero the microservice's default is used.
+	Limit int
+}
+
+// First sets the limit iff it is set, the first page has no cursor.
+func (cp CursorPagination) First(q url.Values) {
+	if cp.Limit > 0 {
+		q.Set(param(cp.LimitParam, "limit"), strconv.Itoa(cp.Limit))
+	}
+}
+
+// Next sets the cursor to the next cursor of the current page.
+func (cp CursorPagination) Next(_ *url.URL, q url.Values, p PageInfo) bool {
+	if p.Meta.NextCursor == "" || p.Count == 0 {
+		return false
+	}
+	q.Set(param(cp.CursorParam, "cursor"), p.Meta.NextCursor)
+	return true
+}
+
+// LinkPagination follows the rel="next" link of the Link header as defined
+// by RFC 8288 https://datatracker.ietf.org/doc/html/rfc8288.
+type LinkPagination struct{}
+
+// First does not set any query params, the first page is the URL given.
+func (LinkPagination) First(url.Values) {}
+
+// Next replaces the URL and query params with the next link.
+func (LinkPagination) Next(URL *url.URL, q url.Values, p PageInfo) bool {
+	next := nextLink(p.Header)
+	if next == "" {
+		return false
+	}
+	u, err := p.URL.Parse(next)
+	if err != nil {
+		return false
+	}
+	for key := range q {
+		delete(q, key)
+	}
+	for key, values := range u.Query() {
+		q[key] = values
+	}
+	u.RawQuery = ""
+	*URL = *u
+	return true
+}
+
+// nextLink returns the target of the rel="next" link in the Link header.
+func nextLink(h http.Header) string {
+	for _, header := range h.Values("Link") {
+		for _, link := range strings.Split(header, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, p := range parts[1:] {
+				key, value, ok := strings.Cut(strings.TrimSpace(p), "=")
+				if !ok || strings.ToLower(key) != "rel" {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(value, `"`)) {
+					if strings.ToLower(rel) == "next" {
+						return strings.Trim(target, "<>")
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// param returns the name of the query param or the default name.
+func param(name string, def string) string {
+	if name == "" {
+		return def
+	}
+	return name
+}
+
+// limit returns the page size that is configured or the page size the
+// microservice reports.
+func limit(l int, p PageInfo) int {
+	if l > 0 {
+		return l
+	}
+	return p.Meta.Limit
+}
+
+// last reports whether p is the last page, seen is the number of items that
+// have been received up to and including p.
+func last(p PageInfo, seen int, l int) bool {
+	if p.Count == 0 {
+		return true
+	}
+	if p.Meta.HasMore != nil {
+		return !*p.Meta.HasMore
+	}
+	if p.Meta.Total > 0 {
+		return seen >= p.Meta.Total
+	}
+	return p.Count < limit(l, p)
+}
+
+// pageResult is the result of fetching a page.
+type pageResult[T any] struct {
+	page Page[T]
+	URL  url.URL
+	q    url.Values
+	more bool
+	e    dutil.Error
+}
+
+// Paginator iterates lazily over the items of a list endpoint, the pages
+// are only requested once the items of the previous page have been
+// consumed.
+//
+//	p := msp.NewPaginator[User](s, s.URL, nil, "users", msp.PagePagination{Limit: 50})
+//	for p.Next(ctx) {
+//		u := p.Item()
+//	}
+//	if e := p.Err(); e != nil {
+//		...
+//	}
+type Paginator[T any] struct {
+	// Key is the key in the data of the response the items are listed under.
+	Key string
+	// Header is the additional headers sent with each page request.
+	Header http.Header
+	// Prefetch requests the next page concurrently while the items of the
+	// current page are being consumed.
+	Prefetch bool
+
+	service  *Service
+	strategy PageStrategy
+	target   url.URL
+	query    url.Values
+	more     bool
+	page     Page[T]
+	i        int
+	item     T
+	pending  chan pageResult[T]
+	e        dutil.Error
+}
+
+// NewPaginator creates a Paginator for the list endpoint at the URL, the
+// items are decoded from the data of the response under the key and the
+// pages are requested according to the strategy.
+func NewPaginator[T any](s *Service, URL url.URL, query url.Values, key string, strategy PageStrategy) *Paginator[T] {
+	q := make(url.Values)
+	for k, values := range query {
+		q[k] = append([]string(nil), values...)
+	}
+	strategy.First(q)
+	return &Paginator[T]{
+		Key:      key,
+		service:  s,
+		strategy: strategy,
+		target:   URL,
+		query:    q,
+		more:     true,
+	}
+}
+
+// Next advances the paginator to the next item, requesting the next page
+// if necessary. Next returns false when there are no more items or an error
+// occurred, the error is returned by Err.
+func (p *Paginator[T]) Next(ctx context.Context) bool {
+	for {
+		if p.e != nil {
+			return false
+		}
+		if p.i < len(p.page.Items) {
+			p.item = p.page.Items[p.i]
+			p.i++
+			return true
+		}
+		if !p.more {
+			return false
+		}
+		if err := ctx.Err(); err != nil {
+			p.e = dutil.NewErr(500, "context", []string{err.Error()})
+			return false
+		}
+
+		var r pageResult[T]
+		if p.pending != nil {
+			select {
+			case r = <-p.pending:
+			case <-ctx.Done():
+				p.e = dutil.NewErr(500, "context", []string{ctx.Err().Error()})
+				return false
+			}
+			p.pending = nil
+		} else {
+			r = p.fetch(ctx, p.target, p.query)
+		}
+		if r.e != nil {
+			p.e = r.e
+			return false
+		}
+		p.page, p.i = r.page, 0
+		p.target, p.query, p.more = r.URL, r.q, r.more
+
+		if p.more && p.Prefetch {
+			p.pending = make(chan pageResult[T], 1)
+			go func(ch chan<- pageResult[T], URL url.URL, q url.Values) {
+				ch <- p.fetch(ctx, URL, q)
+			}(p.pending, p.target, p.query)
+		}
+	}
+}
+
+// Item returns the current item.
+func (p *Paginator[T]) Item() T {
+	return p.item
+}
+
+// Page returns the information of the page the current item is on.
+func (p *Paginator[T]) Page() PageInfo {
+	return p.page.PageInfo
+}
+
+// Err returns the error that stopped the paginator, if any.
+func (p *Paginator[T]) Err() dutil.Error {
+	return p.e
+}
+
+// All consumes the paginator and returns all the remaining items.
+func (p *Paginator[T]) All(ctx context.Context) ([]T, dutil.Error) {
+	var xt []T
+	for p.Next(ctx) {
+		xt = append(xt, p.Item())
+	}
+	return xt, p.Err()
+}
+
+// fetch requests the page at the URL with the query params and determines
+// the request for the page that follows it.
+func (p *Paginator[T]) fetch(ctx context.Context, URL url.URL, q url.Values) pageResult[T] {
+	resp := struct {
+		Message string                     `json:"message"`
+		Data    map[string]json.RawMessage `json:"data"`
+		Errors  map[string][]string        `json:"errors"`
+	}{}
+
+	res, e := p.service.DoRequestContext(ctx, "GET", URL, q, p.Header, nil)
+	if e != nil {
+		return pageResult[T]{e: e}
+	}
+	xb, e := p.service.Decode(res, nil)
+	if e != nil {
+		return pageResult[T]{e: e}
+	}
+	if res.StatusCode != 200 {
+		return pageResult[T]{e: ResponseError(res, xb)}
+	}
+	err := unmarshal(xb, &resp, p.service.DecodeOptions)
+	if err != nil {
+		return pageResult[T]{e: unmarshalErr(err, xb)}
+	}
+
+	page := Page[T]{
+		PageInfo: PageInfo{
+			URL:    URL,
+			Header: res.Header,
+		},
+	}
+	if raw, ok := resp.Data[p.Key]; ok {
+		err := unmarshal(raw, &page.Items, p.service.DecodeOptions)
+		if err != nil {
+			return pageResult[T]{e: unmarshalErr(err, xb)}
+		}
+	}
+	if raw, ok := resp.Data["pagination"]; ok {
+		err := unmarshal(raw, &page.Meta, p.service.DecodeOptions)
+		if err != nil {
+			return pageResult[T]{e: unmarshalErr(err, xb)}
+		}
+	}
+	page.Count = len(page.Items)
+
+	// the strategy updates copies to keep the current page request intact
+	next := URL
+	nq := make(url.Values)
+	for k, values := range q {
+		nq[k] = append([]string(nil), values...)
+	}
+	more := p.strategy.Next(&next, nq, page.PageInfo)
+	return pageResult[T]{page: page, URL: next, q: nq, more: more}
+}
@@ -0,0 +1,223 @@
+package msp
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/dottics/dutil"
+	"github.com/johannesscr/micro/microtest"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestPaginator(t *testing.T) {
+	type item struct {
+		ID int `json:"id"`
+	}
+	tt := []struct {
+		name      string
+		strategy  PageStrategy
+		exchanges []*microtest.Exchange
+		EItems    []int
+		EURIs     []string
+	}{
+		{
+			name:     "page pagination",
+			strategy: PagePagination{Limit: 2},
+			exchanges: []*microtest.Exchange{
+				{Response: microtest.Response{Status: 200, Body: `{"data":{"items":[{"id":1},{"id":2}]}}`}},
+				{Response: microtest.Response{Status: 200, Body: `{"data":{"items":[{"id":3}]}}`}},
+			},
+			EItems: []int{1, 2, 3},
+			EURIs:  []string{"/items?limit=2&page=1", "/items?limit=2&page=2"},
+		},
+		{
+			name:     "page pagination with total",
+			strategy: PagePagination{Limit: 2},
+			exchanges: []*microtest.Exchange{
+				{Response: microtest.Response{Status: 200, Body: `{"data":{"items":[{"id":1},{"id":2}],"pagination":{"total":4}}}`}},
+				{Response: microtest.Response{Status: 200, Body: `{"data":{"items":[{"id":3},{"id":4}],"pagination":{"total":4}}}`}},
+			},
+			EItems: []int{1, 2, 3, 4},
+			EURIs:  []string{"/items?limit=2&page=1", "/items?limit=2&page=2"},
+		},
+		{
+			name:     "offset pagination",
+			strategy: OffsetPagination{Limit: 2},
+			exchanges: []*microtest.Exchange{
+				{Response: microtest.Response{Status: 200, Body: `{"data":{"items":[{"id":1},{"id":2}],"pagination":{"has_more":true}}}`}},
+				{Response: microtest.Response{Status: 200, Body: `{"data":{"items":[{"id":3},{"id":4}],"pagination":{"has_more":false}}}`}},
+			},
+			EItems: []int{1, 2, 3, 4},
+			EURIs:  []string{"/items?limit=2&offset=0", "/items?limit=2&offset=2"},
+		},
+		{
+			name:     "cursor pagination",
+			strategy: CursorPagination{},
+			exchanges: []*microtest.Exchange{
+				{Response: microtest.Response{Status: 200, Body: `{"data":{"items":[{"id":1}],"pagination":{"next_cursor":"abc"}}}`}},
+				{Response: microtest.Response{Status: 200, Body: `{"data":{"items":[{"id":2}],"pagination":{"next_cursor":""}}}`}},
+			},
+			EItems: []int{1, 2},
+			EURIs:  []string{"/items", "/items?cursor=abc"},
+		},
+		{
+			name:     "link pagination",
+			strategy: LinkPagination{},
+			exchanges: []*microtest.Exchange{
+				{Response: microtest.Response{
+					Status: 200,
+					Header: http.Header{"Link": {`</items?page=2>; rel="next", </items?page=1>; rel="first"`}},
+					Body:   `{"data":{"items":[{"id":1}]}}`,
+				}},
+				{Response: microtest.Response{Status: 200, Body: `{"data":{"items":[{"id":2}]}}`}},
+			},
+			EItems: []int{1, 2},
+			EURIs:  []string{"/items", "/items?page=2"},
+		},
+	}
+
+	for _, tc := range tt {
+		for _, prefetch := range []bool{false, true} {
+			name := tc.name
+			if prefetch {
+				name += " prefetch"
+			}
+			t.Run(name, func(t *testing.T) {
+				s := NewService(Config{Name: "micro"})
+				ms := microtest.MockServer(s)
+				defer ms.Server.Close()
+				for _, ex := range tc.exchanges {
+					ms.Append(&microtest.Exchange{Response: ex.Response})
+				}
+
+				s.URL.Path = "/items"
+				p := NewPaginator[item](s, s.URL, nil, "items", tc.strategy)
+				p.Prefetch = prefetch
+				xi, e := p.All(context.Background())
+				if e != nil {
+					t.Fatalf("unexpected error: %v", e)
+				}
+				ids := make([]int, 0, len(xi))
+				for _, i := range xi {
+					ids = append(ids, i.ID)
+				}
+				if !reflect.DeepEqual(ids, tc.EItems) {
+					t.Errorf("expected '%v' got '%v'", tc.EItems, ids)
+				}
+				for i, uri := range tc.EURIs {
+					if ms.Exchanges[i].Request.RequestURI != uri {
+						t.Errorf("expected '%v' got '%v'", uri, ms.Exchanges[i].Request.RequestURI)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestPaginator_error(t *testing.T) {
+	s := NewService(Config{Name: "micro"})
+	ms := microtest.MockServer(s)
+	defer ms.Server.Close()
+	ms.Append(&microtest.Exchange{Response: microtest.Response{
+		Status: 200,
+		Body:   `{"data":{"items":[{"id":1}]}}`,
+	}})
+	ms.Append(&microtest.Exchange{Response: microtest.Response{
+		Status: 503,
+		Body:   `{"errors":{"unavailable":["try again later"]}}`,
+	}})
+
+	p := NewPaginator[map[string]int](s, s.URL, nil, "items", PagePagination{Limit: 1})
+	xi, e := p.All(context.Background())
+	if len(xi) != 1 {
+		t.Errorf("expected 1 got %d", len(xi))
+	}
+	if e == nil {
+		t.Fatalf("expected an error got nil")
+	}
+	if e.Error() != "map[unavailable:[try again later]]" {
+		t.Errorf("expected '%v' got '%v'", "map[unavailable:[try again later]]", e.Error())
+	}
+}
+
+func TestPaginator_DecodeOptions(t *testing.T) {
+	type item struct {
+		ID int `json:"id"`
+	}
+	body := `{"data":{"items":[{"id":1,"name":"jane"}],"pagination":{"page":1}}}`
+	s := NewService(Config{Name: "micro", DecodeOptions: DecodeOptions{UseNumber: true}})
+	ms := microtest.MockServer(s)
+	defer ms.Server.Close()
+
+	// the items are decoded with the options of the service
+	ms.Append(&microtest.Exchange{Response: microtest.Response{Status: 200, Body: body}})
+	p := NewPaginator[map[string]interface{}](s, s.URL, nil, "items", PagePagination{Limit: 2})
+	xi, e := p.All(context.Background())
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if n, ok := xi[0]["id"].(json.Number); !ok || n.String() != "1" {
+		t.Errorf("expected '%v' got '%#v'", json.Number("1"), xi[0]["id"])
+	}
+
+	s.DecodeOptions = DecodeOptions{DisallowUnknownFields: true}
+	ms.Append(&microtest.Exchange{Response: microtest.Response{Status: 200, Body: body}})
+	q := NewPaginator[item](s, s.URL, nil, "items", PagePagination{Limit: 2})
+	_, e = q.All(context.Background())
+	if e == nil {
+		t.Fatalf("expected an error got nil")
+	}
+	errs := dutil.Inst(e).Errors
+	E := `json: unknown field "name"`
+	if len(errs["unmarshal"]) != 1 || errs["unmarshal"][0] != E {
+		t.Errorf("expected '%v' got '%v'", E, errs["unmarshal"])
+	}
+	if len(errs["body"]) != 1 || errs["body"][0] != body {
+		t.Errorf("expected '%v' got '%v'", body, errs["body"])
+	}
+}
+
+func TestPaginator_context(t *testing.T) {
+	s := NewService(Config{Name: "micro"})
+	ms := microtest.MockServer(s)
+	defer ms.Server.Close()
+	ms.Append(&microtest.Exchange{Response: microtest.Response{
+		Status: 200,
+		Body:   `{"data":{"items":[{"id":1}]}}`,
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := NewPaginator[map[string]int](s, s.URL, url.Values{"q": {"x"}}, "items", PagePagination{Limit: 1})
+	if !p.Next(ctx) {
+		t.Fatalf("expected an item got error: %v", p.Err())
+	}
+	cancel()
+	if p.Next(ctx) {
+		t.Errorf("expected no item after cancel")
+	}
+	if p.Err() == nil || p.Err().Error() != "map[context:[context canceled]]" {
+		t.Errorf("expected '%v' got '%v'", "map[context:[context canceled]]", p.Err())
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	tt := []struct {
+		name   string
+		header http.Header
+		E      string
+	}{
+		{name: "no link", header: http.Header{}, E: ""},
+		{name: "next", header: http.Header{"Link": {`<https://a.com/x?p=2>; rel="next"`}}, E: "https://a.com/x?p=2"},
+		{name: "multiple rels", header: http.Header{"Link": {`<https://a.com/x?p=1>; rel="prev", <https://a.com/x?p=3>; rel="next last"`}}, E: "https://a.com/x?p=3"},
+		{name: "no next", header: http.Header{"Link": {`<https://a.com/x?p=1>; rel="prev"`}}, E: ""},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if next := nextLink(tc.header); next != tc.E {
+				t.Errorf("expected '%v' got '%v'", tc.E, next)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package msp
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dottics/dutil"
+	"mime"
+	"net/http"
+	"sort"
+)
+
+// ProblemContentType is the media type of a Problem Details response.
+const ProblemContentType = "application/problem+json"
+
+// ProblemDetails is the error response of a microservice as defined by
+// RFC 7807 https://datatracker.ietf.org/doc/html/rfc7807.
+type ProblemDetails struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail"`
+	Instance string `json:"instance"`
+	// Extensions are the additional members of the problem.
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// UnmarshalJSON unmarshals the problem and collects all the members that
+// are not defined by RFC 7807 as Extensions.
+func (p *ProblemDetails) UnmarshalJSON(xb []byte) error {
+	type problem ProblemDetails
+	err := json.Unmarshal(xb, (*problem)(p))
+	if err != nil {
+		return err
+	}
+	members := make(map[string]interface{})
+	err = json.Unmarshal(xb, &members)
+	if err != nil {
+		return err
+	}
+	for _, key := range []string{"type", "title", "status", "detail", "instance"} {
+		delete(members, key)
+	}
+	p.Extensions = nil
+	if len(members) > 0 {
+		p.Extensions = members
+	}
+	return nil
+}
+
+// Err maps the problem to the same error an envelope error response
+// produces. The problem members are listed under their member names, the
+// "errors" extension is merged with the errors when it has the same
+// structure as the errors of an envelope and any other extension is listed
+// under its name as JSON.
+func (p ProblemDetails) Err() *dutil.Err {
+	e := &dutil.Err{
+		Status: p.Status,
+		Errors: make(map[string][]string),
+	}
+	for key, value := range map[string]string{
+		"type":     p.Type,
+		"title":    p.Title,
+		"detail":   p.Detail,
+		"instance": p.Instance,
+	} {
+		if value != "" {
+			e.Errors[key] = []string{value}
+		}
+	}
+
+	keys := make([]string, 0, len(p.Extensions))
+	for key := range p.Extensions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "errors" {
+			if errors, ok := envelopeErrors(p.Extensions[key]); ok {
+				for k, values := range errors {
+					e.Errors[k] = append(e.Errors[k], values...)
+				}
+				continue
+			}
+		}
+		xb, _ := json.Marshal(p.Extensions[key])
+		e.Errors[key] = []string{string(xb)}
+	}
+	return e
+}
+
+// envelopeErrors converts an unmarshalled value to errors if it has the
+// structure of envelope errors, a map of string slices.
+func envelopeErrors(v interface{}) (map[string][]string, bool) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	errors := make(map[string][]string)
+	for key, value := range m {
+		switch value := value.(type) {
+		case string:
+			errors[key] = []string{value}
+		case []interface{}:
+			for _, x := range value {
+				s, ok := x.(string)
+				if !ok {
+					return nil, false
+				}
+				errors[key] = append(errors[key], s)
+			}
+		default:
+			return nil, false
+		}
+	}
+	return errors, true
+}
+
+// IsProblem reports whether the response is a Problem Details response.
+func IsProblem(res *http.Response) bool {
+	mt, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	return err == nil && mt == ProblemContentType
+}
+
+// ResponseError returns the error of an error response from a microservice
+// given the body read from the response. Both the standard envelope
+//
+//	{"message": "...", "data": {}, "errors": {"key": ["..."]}}
+//
+// and Problem Details responses result in the same error, so that error
+// responses are handled uniformly.
+func ResponseError(res *http.Response, xb []byte) dutil.Error {
+	if IsProblem(res) {
+		p := ProblemDetails{}
+		err := json.Unmarshal(xb, &p)
+		if err == nil {
+			e := p.Err()
+			if e.Status == 0 {
+				e.Status = res.StatusCode
+			}
+			return e
+		}
+	}
+
+	resp := struct {
+		Errors map[string][]string `json:"errors"`
+	}{}
+	err := json.Unmarshal(xb, &resp)
+	if err != nil {
+		return &dutil.Err{
+			Status: res.StatusCode,
+			Errors: map[string][]string{
+				"response": {fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode))},
+				"body":     {excerpt(xb)},
+			},
+		}
+	}
+	return &dutil.Err{
+		Status: res.StatusCode,
+		Errors: resp.Errors,
+	}
+}
@@ -0,0 +1,122 @@
+package msp
+
+import (
+	"github.com/dottics/dutil"
+	"github.com/johannesscr/micro/microtest"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestResponseError(t *testing.T) {
+	tt := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		E           dutil.Err
+	}{
+		{
+			name:        "envelope",
+			status:      404,
+			contentType: "application/json",
+			body:        `{"message":"not found","data":{},"errors":{"user":["not found"]}}`,
+			E: dutil.Err{
+				Status: 404,
+				Errors: map[string][]string{"user": {"not found"}},
+			},
+		},
+		{
+			name:        "problem details",
+			status:      403,
+			contentType: "application/problem+json; charset=utf-8",
+			body:        `{"type":"https://example.com/probs/out-of-credit","title":"You do not have enough credit.","status":403,"detail":"Your current balance is 30, but that costs 50.","instance":"/account/12345/msgs/abc","balance":30}`,
+			E: dutil.Err{
+				Status: 403,
+				Errors: map[string][]string{
+					"type":     {"https://example.com/probs/out-of-credit"},
+					"title":    {"You do not have enough credit."},
+					"detail":   {"Your current balance is 30, but that costs 50."},
+					"instance": {"/account/12345/msgs/abc"},
+					"balance":  {"30"},
+				},
+			},
+		},
+		{
+			name:        "problem details with errors extension",
+			status:      422,
+			contentType: "application/problem+json",
+			body:        `{"title":"Validation failed","errors":{"email":["is required"],"name":"too long"}}`,
+			E: dutil.Err{
+				Status: 422,
+				Errors: map[string][]string{
+					"title": {"Validation failed"},
+					"email": {"is required"},
+					"name":  {"too long"},
+				},
+			},
+		},
+		{
+			name:        "problem details with other status",
+			status:      500,
+			contentType: "application/problem+json",
+			body:        `{"title":"Boom","status":503}`,
+			E: dutil.Err{
+				Status: 503,
+				Errors: map[string][]string{"title": {"Boom"}},
+			},
+		},
+		{
+			name:        "not json",
+			status:      502,
+			contentType: "text/html",
+			body:        `<html>bad gateway</html>`,
+			E: dutil.Err{
+				Status: 502,
+				Errors: map[string][]string{
+					"response": {"502 Bad Gateway"},
+					"body":     {"<html>bad gateway</html>"},
+				},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			res := &http.Response{
+				StatusCode: tc.status,
+				Header:     http.Header{"Content-Type": {tc.contentType}},
+			}
+			e := dutil.Inst(ResponseError(res, []byte(tc.body)))
+			if e.Status != tc.E.Status {
+				t.Errorf("expected %d got %d", tc.E.Status, e.Status)
+			}
+			if !reflect.DeepEqual(map[string][]string(e.Errors), map[string][]string(tc.E.Errors)) {
+				t.Errorf("expected '%v' got '%v'", tc.E.Errors, e.Errors)
+			}
+		})
+	}
+}
+
+func TestService_HealthCheck_problem(t *testing.T) {
+	s := NewService(Config{Name: "micro"})
+	ms := microtest.MockServer(s)
+	defer ms.Server.Close()
+	ms.Append(&microtest.Exchange{Response: microtest.Response{
+		Status: 503,
+		Header: http.Header{"Content-Type": {ProblemContentType}},
+		Body:   `{"title":"Service Unavailable","detail":"database is down"}`,
+	}})
+
+	alive, e := s.HealthCheck()
+	if alive {
+		t.Errorf("expected '%v' got '%v'", false, alive)
+	}
+	E := "map[detail:[database is down] title:[Service Unavailable]]"
+	if e == nil || e.Error() != E {
+		t.Errorf("expected '%v' got '%v'", E, e)
+	}
+	if s.URL.Path != "" {
+		t.Errorf("expected the service URL to be unchanged got '%v'", s.URL.Path)
+	}
+}
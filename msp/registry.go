@@ -0,0 +1,367 @@
+package msp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dottics/dutil"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errRegistryClosed is the error of a request made after the registry was
+// closed.
+var errRegistryClosed = errors.New("msp: registry is closed")
+
+// RoundTripperFunc is an adapter to use a function as an
+// http.RoundTripper.
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req).
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// CallStats are the statistics of the calls made to a microservice.
+type CallStats struct {
+	Requests int64 `json:"requests"`
+	// Errors are the calls that failed to get a response or got a 5xx
+	// response.
+	Errors   int64 `json:"errors"`
+	InFlight int64 `json:"in_flight"`
+	// Fallbacks are the failed calls that were replaced by a fallback
+	// response.
+	Fallbacks int64 `json:"fallbacks"`
+	// Latency is the mean latency of the recent calls.
+	Latency    time.Duration `json:"-"`
+	LastStatus int           `json:"last_status,omitempty"`
+	LastError  string        `json:"last_error,omitempty"`
+	LastCallAt time.Time     `json:"last_call_at"`
+}
+
+// MarshalJSON marshals the stats with the latency as a duration string.
+func (c CallStats) MarshalJSON() ([]byte, error) {
+	type stats CallStats
+	return json.Marshal(struct {
+		stats
+		Latency string `json:"latency"`
+	}{
+		stats:   stats(c),
+		Latency: c.Latency.String(),
+	})
+}
+
+// recentCalls is the number of calls used for the mean latency.
+const recentCalls = 100
+
+// callStats records the statistics of the calls made to a microservice.
+type callStats struct {
+	mu        sync.Mutex
+	stats     CallStats
+	latencies []time.Duration
+	next      int
+}
+
+// record records the outcome of a call.
+func (c *callStats) record(res *http.Response, err error, latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Requests++
+	c.stats.LastCallAt = time.Now()
+	if err != nil {
+		c.stats.Errors++
+		c.stats.LastStatus = 0
+		c.stats.LastError = err.Error()
+	} else {
+		c.stats.LastStatus = res.StatusCode
+		if res.StatusCode >= 500 {
+			c.stats.Errors++
+		}
+	}
+	if len(c.latencies) < recentCalls {
+		c.latencies = append(c.latencies, latency)
+	} else {
+		c.latencies[c.next] = latency
+		c.next = (c.next + 1) % recentCalls
+	}
+}
+
+// fallback records the use of a fallback response.
+func (c *callStats) fallback() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Fallbacks++
+}
+
+// inFlight adds delta to the number of calls in-flight.
+func (c *callStats) inFlight(delta int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.InFlight += delta
+}
+
+// snapshot returns a copy of the statistics.
+func (c *callStats) snapshot() CallStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	if len(c.latencies) > 0 {
+		var total time.Duration
+		for _, l := range c.latencies {
+			total += l
+		}
+		stats.Latency = total / time.Duration(len(c.latencies))
+	}
+	return stats
+}
+
+// trackedBody calls done once when the body is read to the end, fails or
+// is closed.
+type trackedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.once.Do(b.done)
+	}
+	return n, err
+}
+
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
+
+// registered is a service in the registry.
+type registered struct {
+	service *Service
+	stats   *callStats
+	// transport is the transport of the service wrapped by the
+	// interceptors.
+	transport http.RoundTripper
+}
+
+// ServiceInfo is the introspection of a service in the registry.
+type ServiceInfo struct {
+	Name  string    `json:"name"`
+	URL   string    `json:"url"`
+	Stats CallStats `json:"stats"`
+}
+
+// Registry builds and holds the microservices by name, with shared
+// defaults for the timeouts, interceptors and logger. A call is in-flight
+// until its response body is read to the end or closed, Close waits for
+// the in-flight calls to drain.
+type Registry struct {
+	// Timeout is the default Config.Timeout.
+	Timeout time.Duration
+	// Interceptors wrap the transport of each service, outside of the
+	// interceptors of the Config.
+	Interceptors []Interceptor
+	// Logger is the default Config.Logger.
+	Logger *log.Logger
+	// DrainTimeout is the maximum duration Close waits for in-flight
+	// calls, default 30s.
+	DrainTimeout time.Duration
+
+	mu       sync.RWMutex
+	services map[string]*registered
+	closed   bool
+	inflight sync.WaitGroup
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		DrainTimeout: 30 * time.Second,
+		services:     make(map[string]*registered),
+	}
+}
+
+// Register builds the service from the config with the defaults of the
+// registry and registers it by the name of the config, a service with the
+// same name is replaced.
+func (r *Registry) Register(config Config) (*Service, dutil.Error) {
+	if config.Name == "" {
+		e := dutil.NewErr(500, "registry", []string{"a service requires a name"})
+		return nil, e
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = r.Timeout
+	}
+	if config.Logger == nil {
+		config.Logger = r.Logger
+	}
+	stats := &callStats{}
+	interceptors := []Interceptor{r.track(stats)}
+	interceptors = append(interceptors, r.Interceptors...)
+	config.Interceptors = append(interceptors, config.Interceptors...)
+	// the innermost interceptor receives the transport of the service
+	rs := &registered{stats: stats}
+	config.Interceptors = append(config.Interceptors, func(next http.RoundTripper) http.RoundTripper {
+		rs.transport = next
+		return next
+	})
+	onFallback := config.OnFallback
+	config.OnFallback = func(call FallbackCall) {
+		stats.fallback()
+		if onFallback != nil {
+			onFallback(call)
+		}
+	}
+	s := NewService(config)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		e := dutil.NewErr(500, "registry", []string{errRegistryClosed.Error()})
+		return nil, e
+	}
+	rs.service = s
+	r.services[config.Name] = rs
+	return s, nil
+}
+
+// track creates the interceptor that records the statistics of the calls
+// and tracks the in-flight calls.
+func (r *Registry) track(stats *callStats) Interceptor {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			r.mu.RLock()
+			if r.closed {
+				r.mu.RUnlock()
+				return nil, errRegistryClosed
+			}
+			r.inflight.Add(1)
+			r.mu.RUnlock()
+			stats.inFlight(1)
+			done := func() {
+				stats.inFlight(-1)
+				r.inflight.Done()
+			}
+
+			start := time.Now()
+			res, err := next.RoundTrip(req)
+			stats.record(res, err, time.Since(start))
+			if err != nil {
+				done()
+				return nil, err
+			}
+			res.Body = &trackedBody{ReadCloser: res.Body, done: done}
+			return res, nil
+		})
+	}
+}
+
+// Get returns the service with the name.
+func (r *Registry) Get(name string) (*Service, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if rs, ok := r.services[name]; ok {
+		return rs.service, true
+	}
+	return nil, false
+}
+
+// Names returns the sorted names of the services.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.services))
+	for name := range r.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Stats returns the call statistics of the service with the name.
+func (r *Registry) Stats(name string) (CallStats, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if rs, ok := r.services[name]; ok {
+		return rs.stats.snapshot(), true
+	}
+	return CallStats{}, false
+}
+
+// Close rejects new calls and waits up to the DrainTimeout for the
+// in-flight calls to complete, then closes the idle connections.
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	r.mu.Unlock()
+
+	timeout := r.DrainTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	drained := make(chan struct{})
+	go func() {
+		r.inflight.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-time.After(timeout):
+		err = fmt.Errorf("msp: in-flight calls did not drain within %v", timeout)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, rs := range r.services {
+		if t, ok := rs.transport.(interface{ CloseIdleConnections() }); ok {
+			t.CloseIdleConnections()
+		}
+	}
+	return err
+}
+
+// ServeHTTP responds with each registered service, its resolved URL and
+// the statistics of its recent calls in the standard envelope.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	r.mu.RLock()
+	services := make([]ServiceInfo, 0, len(r.services))
+	for name, rs := range r.services {
+		services = append(services, ServiceInfo{
+			Name:  name,
+			URL:   rs.service.URL.String(),
+			Stats: rs.stats.snapshot(),
+		})
+	}
+	r.mu.RUnlock()
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+
+	resp := struct {
+		Message string              `json:"message"`
+		Data    interface{}         `json:"data"`
+		Errors  map[string][]string `json:"errors"`
+	}{
+		Message: "services",
+		Data: map[string]interface{}{
+			"services": services,
+		},
+		Errors: make(map[string][]string),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,244 @@
+package msp
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/johannesscr/micro/microtest"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRegistry_Register(t *testing.T) {
+	buf := &bytes.Buffer{}
+	var order []string
+	interceptor := func(name string) Interceptor {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+
+	r := NewRegistry()
+	r.Timeout = 2 * time.Second
+	r.Logger = log.New(buf, "", 0)
+	r.Interceptors = []Interceptor{interceptor("registry")}
+
+	s, e := r.Register(Config{Name: "users", Interceptors: []Interceptor{interceptor("service")}})
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if _, e := r.Register(Config{}); e == nil {
+		t.Errorf("expected an error got nil")
+	}
+	if got, ok := r.Get("users"); !ok || got != s {
+		t.Errorf("expected '%v' got '%v'", s, got)
+	}
+	if _, ok := r.Get("orders"); ok {
+		t.Errorf("expected no service")
+	}
+	if s.Client.Timeout != 2*time.Second {
+		t.Errorf("expected '%v' got '%v'", 2*time.Second, s.Client.Timeout)
+	}
+
+	ms := microtest.MockServer(s)
+	defer ms.Server.Close()
+	ms.Append(&microtest.Exchange{Response: microtest.Response{Status: 200, Body: `{}`}})
+	res, e := s.DoRequest("GET", s.URL, nil, nil, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	_, _ = s.Decode(res, nil)
+
+	if strings.Join(order, ",") != "registry,service" {
+		t.Errorf("expected '%v' got '%v'", "registry,service", order)
+	}
+	if !strings.Contains(buf.String(), "- users-service -> [GET") {
+		t.Errorf("expected the request to be logged got '%v'", buf.String())
+	}
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	users, _ := r.Register(Config{Name: "users"})
+	_, _ = r.Register(Config{Name: "orders"})
+	ms := microtest.MockServer(users)
+	defer ms.Server.Close()
+
+	for _, status := range []int{200, 500} {
+		ms.Append(&microtest.Exchange{Response: microtest.Response{Status: status, Body: `{}`}})
+		res, e := users.DoRequest("GET", users.URL, nil, nil, nil)
+		if e != nil {
+			t.Fatalf("unexpected error: %v", e)
+		}
+		_, _ = users.Decode(res, nil)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/services", nil))
+	res, xb := microtest.ReadRecorder(rec)
+	if res.StatusCode != 200 {
+		t.Errorf("expected %d got %d", 200, res.StatusCode)
+	}
+	resp := struct {
+		Data struct {
+			Services []struct {
+				Name  string `json:"name"`
+				URL   string `json:"url"`
+				Stats struct {
+					Requests   int64  `json:"requests"`
+					Errors     int64  `json:"errors"`
+					InFlight   int64  `json:"in_flight"`
+					LastStatus int    `json:"last_status"`
+					Latency    string `json:"latency"`
+				} `json:"stats"`
+			} `json:"services"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(xb, &resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	services := resp.Data.Services
+	if len(services) != 2 {
+		t.Fatalf("expected 2 got %d", len(services))
+	}
+	if services[0].Name != "orders" || services[1].Name != "users" {
+		t.Errorf("expected the services sorted by name got '%+v'", services)
+	}
+	stats := services[1].Stats
+	if services[1].URL != users.URL.String() {
+		t.Errorf("expected '%v' got '%v'", users.URL.String(), services[1].URL)
+	}
+	if stats.Requests != 2 || stats.Errors != 1 || stats.InFlight != 0 || stats.LastStatus != 500 {
+		t.Errorf("expected 2 requests, 1 error, 0 in-flight and last status 500 got '%+v'", stats)
+	}
+	if stats.Latency == "" || stats.Latency == "0s" {
+		t.Errorf("expected a latency got '%v'", stats.Latency)
+	}
+}
+
+func TestRegistry_Close(t *testing.T) {
+	r := NewRegistry()
+	s, _ := r.Register(Config{Name: "users"})
+	ms := microtest.MockServer(s)
+	defer ms.Server.Close()
+	ms.Append(&microtest.Exchange{Response: microtest.Response{Status: 200, Body: `{}`}})
+
+	res, e := s.DoRequest("GET", s.URL, nil, nil, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if stats, _ := r.Stats("users"); stats.InFlight != 1 {
+		t.Errorf("expected %d got %d", 1, stats.InFlight)
+	}
+
+	closed := make(chan error)
+	go func() {
+		closed <- r.Close()
+	}()
+	select {
+	case <-closed:
+		t.Fatalf("expected Close to wait for the in-flight call")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_, _ = s.Decode(res, nil)
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected Close to return once the call completed")
+	}
+
+	// new calls are rejected once the registry is closed
+	_, e = s.DoRequest("GET", s.URL, nil, nil, nil)
+	if e == nil || !strings.Contains(e.Error(), "registry is closed") {
+		t.Errorf("expected a registry closed error got '%v'", e)
+	}
+	if _, e := r.Register(Config{Name: "orders"}); e == nil {
+		t.Errorf("expected an error got nil")
+	}
+}
+
+func TestRegistry_Close_idle(t *testing.T) {
+	var opened, closed int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			atomic.AddInt32(&opened, 1)
+		case http.StateClosed:
+			atomic.AddInt32(&closed, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+	get := func() {
+		res, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+	}
+
+	get()
+	r := NewRegistry()
+	s, _ := r.Register(Config{Name: "users", Timeout: time.Second})
+	URL, _ := url.Parse(ts.URL)
+	s.SetURL(URL.Scheme, URL.Host)
+	res, e := s.DoRequest("GET", s.URL, nil, nil, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	_, _ = s.Decode(res, nil)
+	if err := r.Close(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// the idle connection of the service is closed
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&closed) != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&closed); n != 1 {
+		t.Errorf("expected %d got %d", 1, n)
+	}
+	// the idle connection of the default transport is reused
+	n := atomic.LoadInt32(&opened)
+	get()
+	if m := atomic.LoadInt32(&opened); m != n {
+		t.Errorf("expected %d got %d", n, m)
+	}
+}
+
+func TestRegistry_CloseTimeout(t *testing.T) {
+	r := NewRegistry()
+	r.DrainTimeout = 10 * time.Millisecond
+	s, _ := r.Register(Config{Name: "users"})
+	ms := microtest.MockServer(s)
+	defer ms.Server.Close()
+	ms.Append(&microtest.Exchange{Response: microtest.Response{Status: 200, Body: `{}`}})
+
+	res, e := s.DoRequest("GET", s.URL, nil, nil, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	defer res.Body.Close()
+	if err := r.Close(); err == nil {
+		t.Errorf("expected an error got nil")
+	}
+}
@@ -0,0 +1,137 @@
+package msp
+
+import (
+	"context"
+	"fmt"
+	"github.com/dottics/dutil"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Params are the values of the placeholders of a route template.
+type Params map[string]string
+
+// routeKey is the context key of the route template of a call.
+type routeKey struct{}
+
+// withRoute returns a context with the route template of the call.
+func withRoute(ctx context.Context, template string) context.Context {
+	return context.WithValue(ctx, routeKey{}, template)
+}
+
+// RouteTemplate returns the route template of the call made with the
+// context, e.g. "/users/{uuid}", to be used as a label by interceptors for
+// metrics and logging. It is empty if the call was not made with DoRoute.
+func RouteTemplate(ctx context.Context) string {
+	template, _ := ctx.Value(routeKey{}).(string)
+	return template
+}
+
+// Route resolves the route template, e.g. "/users/{uuid}/orders/{id}", to
+// the URL of the microservice prefixed with the BasePath of the service.
+// The params are path escaped, so that a value containing a slash is a
+// single path segment. All the placeholders must be filled with a
+// non-empty value other than "." and "..", and all the params must have a
+// placeholder.
+func (s *Service) Route(template string, params Params) (url.URL, dutil.Error) {
+	URL := s.URL
+	path, raw, e := expand(template, params)
+	if e != nil {
+		return URL, e
+	}
+	base := strings.TrimSuffix(s.BasePath, "/")
+	if base != "" && !strings.HasPrefix(base, "/") {
+		base = "/" + base
+	}
+	URL.Path = base + path
+	URL.RawPath = (&url.URL{Path: base}).EscapedPath() + raw
+	return URL, nil
+}
+
+// DoRoute resolves the route template with Route and makes the request,
+// the route template is recorded on the context of the request, see
+// RouteTemplate.
+func (s *Service) DoRoute(ctx context.Context, method string, template string, params Params, query url.Values, headers http.Header, payload io.Reader) (*http.Response, dutil.Error) {
+	URL, e := s.Route(template, params)
+	if e != nil {
+		return nil, e
+	}
+	return s.DoRequestContext(withRoute(ctx, template), method, URL, query, headers, payload)
+}
+
+// expand fills the placeholders of the template with the params and
+// returns the unescaped and escaped path.
+func expand(template string, params Params) (string, string, dutil.Error) {
+	if !strings.HasPrefix(template, "/") {
+		template = "/" + template
+	}
+	var path, raw strings.Builder
+	var errs []string
+	used := make(map[string]bool)
+	rest := template
+	for rest != "" {
+		i := strings.IndexByte(rest, '{')
+		if i < 0 {
+			path.WriteString(rest)
+			raw.WriteString((&url.URL{Path: rest}).EscapedPath())
+			break
+		}
+		path.WriteString(rest[:i])
+		raw.WriteString((&url.URL{Path: rest[:i]}).EscapedPath())
+		j := strings.IndexByte(rest[i:], '}')
+		if j < 0 {
+			errs = append(errs, fmt.Sprintf("unclosed placeholder in %s", template))
+			break
+		}
+		name := rest[i+1 : i+j]
+		if !validPlaceholder(name) {
+			errs = append(errs, fmt.Sprintf("invalid placeholder {%s}", name))
+		}
+		value, ok := params[name]
+		if !ok || value == "" {
+			errs = append(errs, fmt.Sprintf("missing param %s", name))
+		}
+		// PathEscape does not escape the dot segments, which would change
+		// the path of the URL
+		if value == "." || value == ".." {
+			errs = append(errs, fmt.Sprintf("invalid param %s %s", name, value))
+		}
+		used[name] = true
+		path.WriteString(value)
+		raw.WriteString(url.PathEscape(value))
+		rest = rest[i+j+1:]
+	}
+
+	unused := make([]string, 0)
+	for name := range params {
+		if !used[name] {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	for _, name := range unused {
+		errs = append(errs, fmt.Sprintf("unknown param %s", name))
+	}
+	if len(errs) > 0 {
+		e := dutil.NewErr(500, "route", errs)
+		return "", "", e
+	}
+	return path.String(), raw.String(), nil
+}
+
+// validPlaceholder reports whether the name of a placeholder only contains
+// letters, digits and underscores.
+func validPlaceholder(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package msp
+
+import (
+	"bytes"
+	"context"
+	"github.com/johannesscr/micro/microtest"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestService_Route(t *testing.T) {
+	type E struct {
+		URL string
+		e   string
+	}
+	tt := []struct {
+		name     string
+		basePath string
+		template string
+		params   Params
+		E        E
+	}{
+		{
+			name:     "no placeholders",
+			template: "/users",
+			E:        E{URL: "http://micro.test/users"},
+		},
+		{
+			name:     "placeholders",
+			template: "/users/{uuid}/orders/{id}",
+			params:   Params{"uuid": "a1", "id": "7"},
+			E:        E{URL: "http://micro.test/users/a1/orders/7"},
+		},
+		{
+			name:     "escapes params",
+			template: "/users/{uuid}",
+			params:   Params{"uuid": "../admin/x y?z"},
+			E:        E{URL: "http://micro.test/users/..%2Fadmin%2Fx%20y%3Fz"},
+		},
+		{
+			name:     "base path",
+			basePath: "api/v1/",
+			template: "users/{uuid}",
+			params:   Params{"uuid": "a1"},
+			E:        E{URL: "http://micro.test/api/v1/users/a1"},
+		},
+		{
+			name:     "missing param",
+			template: "/users/{uuid}/orders/{id}",
+			params:   Params{"uuid": "a1", "id": ""},
+			E:        E{e: "missing param id"},
+		},
+		{
+			name:     "dot param",
+			template: "/users/{id}/orders",
+			params:   Params{"id": "."},
+			E:        E{e: "invalid param id ."},
+		},
+		{
+			name:     "dot-dot param",
+			template: "/users/{id}/orders",
+			params:   Params{"id": ".."},
+			E:        E{e: "invalid param id .."},
+		},
+		{
+			name:     "unknown param",
+			template: "/users/{uuid}",
+			params:   Params{"uuid": "a1", "UUID": "a2"},
+			E:        E{e: "unknown param UUID"},
+		},
+		{
+			name:     "unclosed placeholder",
+			template: "/users/{uuid",
+			params:   Params{"uuid": "a1"},
+			E:        E{e: "unclosed placeholder in /users/{uuid"},
+		},
+		{
+			name:     "invalid placeholder",
+			template: "/users/{u-id}",
+			params:   Params{"u-id": "a1"},
+			E:        E{e: "invalid placeholder {u-id}"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewService(Config{Name: "micro", BasePath: tc.basePath})
+			s.SetURL("http", "micro.test")
+			URL, e := s.Route(tc.template, tc.params)
+			if tc.E.e != "" {
+				if e == nil {
+					t.Fatalf("expected an error got nil")
+				}
+				if !strings.Contains(e.Error(), tc.E.e) {
+					t.Errorf("expected '%v' got '%v'", tc.E.e, e.Error())
+				}
+				return
+			}
+			if e != nil {
+				t.Fatalf("unexpected error: %v", e)
+			}
+			if URL.String() != tc.E.URL {
+				t.Errorf("expected '%v' got '%v'", tc.E.URL, URL.String())
+			}
+			if s.URL.Path != "" {
+				t.Errorf("expected the service URL to be unchanged got '%v'", s.URL.Path)
+			}
+		})
+	}
+}
+
+func TestService_DoRoute(t *testing.T) {
+	buf := &bytes.Buffer{}
+	var route string
+	label := func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			route = RouteTemplate(req.Context())
+			return next.RoundTrip(req)
+		})
+	}
+	s := NewService(Config{
+		Name:         "micro",
+		BasePath:     "/api",
+		Interceptors: []Interceptor{label},
+		Logger:       log.New(buf, "", 0),
+	})
+	ms := microtest.MockServer(s)
+	defer ms.Server.Close()
+
+	ex := &microtest.Exchange{Response: microtest.Response{Status: 200, Body: `{}`}}
+	ms.Append(ex)
+	res, e := s.DoRoute(context.Background(), "GET", "/users/{uuid}", Params{"uuid": "a/b"}, nil, nil, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	_, _ = s.Decode(res, nil)
+
+	if p := ex.Request.URL.EscapedPath(); p != "/api/users/a%2Fb" {
+		t.Errorf("expected '%v' got '%v'", "/api/users/a%2Fb", p)
+	}
+	if route != "/users/{uuid}" {
+		t.Errorf("expected '%v' got '%v'", "/users/{uuid}", route)
+	}
+	if !strings.Contains(buf.String(), "(/users/{uuid})") {
+		t.Errorf("expected the route template to be logged got '%v'", buf.String())
+	}
+
+	_, e = s.DoRoute(context.Background(), "GET", "/users/{uuid}", nil, nil, nil, nil)
+	if e == nil {
+		t.Errorf("expected an error got nil")
+	}
+}
@@ -1,6 +1,9 @@
 package msp
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/dottics/dutil"
 	"io"
@@ -9,8 +12,13 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"time"
 )
 
+// Interceptor wraps the transport of a microservice, so that requests and
+// responses can be observed or altered, e.g. for tracing or metrics.
+type Interceptor func(http.RoundTripper) http.RoundTripper
+
 // Service is the microservice-package structure that contains all the
 // information required to connect to the microservice.
 type Service struct {
@@ -18,6 +26,31 @@ type Service struct {
 	Header http.Header
 	URL    url.URL
 	Values url.Values
+	// BasePath is the path prefixed to the route templates, see Route.
+	BasePath string
+	// DecodeOptions are the options used by Service.Decode to read and
+	// unmarshal the responses from the microservice.
+	DecodeOptions DecodeOptions
+	// Credentials are the providers of the credential headers, keyed by
+	// the header name, which are consulted on each request.
+	Credentials map[string]CredentialProvider
+	// Signer signs each request iff it is set.
+	Signer *HMACSigner
+	// Client is the client used to send the requests, if it is nil a
+	// default client is used.
+	Client *http.Client
+	// Health configures the health checks of the microservice.
+	Health HealthConfig
+	// Logger logs the requests to the microservice, if it is nil the
+	// standard logger is used.
+	Logger *log.Logger
+	// Fallback provides the response of a GET or HEAD call that fails
+	// with a transport error, an open circuit or a 5xx response. Use
+	// WithFallback for a fallback of a call with another method.
+	Fallback Fallback
+	// OnFallback is called each time a fallback response is used, e.g. to
+	// report the fallback to metrics.
+	OnFallback func(FallbackCall)
 }
 
 // Config is the configuration for the microservice-package.
@@ -28,6 +61,38 @@ type Config struct {
 	Header    http.Header
 	URL       url.URL
 	Values    url.Values
+	// BasePath is the path prefixed to the route templates, e.g. "/api/v1".
+	BasePath string
+	// DecodeOptions limits the size of and sets how the response bodies
+	// from the microservice are unmarshalled.
+	DecodeOptions DecodeOptions
+	// UserTokenProvider and APIKeyProvider provide the user token and API
+	// key on each request, they take precedence over UserToken and APIKey.
+	UserTokenProvider CredentialProvider
+	APIKeyProvider    CredentialProvider
+	// OAuth2 sets the Authorization header of each request to a bearer
+	// token obtained with the OAuth2 client-credentials grant.
+	OAuth2 *OAuth2Config
+	// Signer signs each request with HMAC-SHA256 iff it is set.
+	Signer *HMACSigner
+	// TLS configures the CAs, client certificate and TLS version used to
+	// connect to the microservice.
+	TLS *TLSConfig
+	// Health configures the health check endpoints, expected status codes
+	// and timeout of the microservice.
+	Health HealthConfig
+	// Timeout is the maximum duration of a request including reading the
+	// response body, zero means no timeout.
+	Timeout time.Duration
+	// Interceptors wrap the transport in order, the first interceptor is
+	// the outermost.
+	Interceptors []Interceptor
+	// Logger logs the requests to the microservice.
+	Logger *log.Logger
+	// Fallback provides the response of a call that fails, see
+	// Service.Fallback.
+	Fallback   Fallback
+	OnFallback func(FallbackCall)
 }
 
 // NewService creates a microservice-package instance. The
@@ -42,8 +107,16 @@ func NewService(config Config) *Service {
 			Scheme: os.Getenv(fmt.Sprintf("%s_SERVICE_SCHEME", strings.ToUpper(config.Name))),
 			Host:   os.Getenv(fmt.Sprintf("%s_SERVICE_HOST", strings.ToUpper(config.Name))),
 		},
-		Header: make(http.Header),
-		Values: make(url.Values),
+		Header:        make(http.Header),
+		Values:        make(url.Values),
+		BasePath:      config.BasePath,
+		DecodeOptions: config.DecodeOptions,
+		Credentials:   make(map[string]CredentialProvider),
+		Signer:        config.Signer,
+		Health:        config.Health,
+		Logger:        config.Logger,
+		Fallback:      config.Fallback,
+		OnFallback:    config.OnFallback,
 	}
 	// set config headers if given
 	if config.Header != nil {
@@ -57,6 +130,36 @@ func NewService(config Config) *Service {
 	s.Header.Set("content-type", "application/json")
 	s.Header.Set("x-user-token", config.UserToken)
 	s.Header.Set("x-api-key", config.APIKey)
+	// credentials that can rotate are set on each request
+	if config.UserTokenProvider != nil {
+		s.Header.Del("x-user-token")
+		s.Credentials["X-User-Token"] = config.UserTokenProvider
+	}
+	if config.APIKeyProvider != nil {
+		s.Header.Del("x-api-key")
+		s.Credentials["X-Api-Key"] = config.APIKeyProvider
+	}
+	if config.TLS != nil || config.Timeout > 0 || len(config.Interceptors) > 0 {
+		// a clone of the default transport, so that closing the idle
+		// connections of the service does not affect other clients
+		var transport http.RoundTripper = http.DefaultTransport.(*http.Transport).Clone()
+		if config.TLS != nil {
+			transport = config.TLS.Transport()
+		}
+		for i := len(config.Interceptors) - 1; i >= 0; i-- {
+			transport = config.Interceptors[i](transport)
+		}
+		s.Client = &http.Client{Transport: transport, Timeout: config.Timeout}
+	}
+	if config.OAuth2 != nil {
+		oauth2 := *config.OAuth2
+		// the token endpoint is trusted with the TLS configuration of the
+		// service by default
+		if oauth2.HTTPClient == nil && config.TLS != nil {
+			oauth2.HTTPClient = &http.Client{Transport: config.TLS.Transport(), Timeout: 30 * time.Second}
+		}
+		s.Credentials["Authorization"] = NewClientCredentials(oauth2)
+	}
 
 	return s
 }
@@ -86,8 +189,29 @@ func (s *Service) SetEnv() error {
 // DoRequest consistently maps and executes requests to the requirements
 // for the service and returns the response.
 func (s *Service) DoRequest(method string, URL url.URL, query url.Values, headers http.Header, payload io.Reader) (*http.Response, dutil.Error) {
-	client := http.Client{}
-	qs := s.Values
+	return s.DoRequestContext(context.Background(), method, URL, query, headers, payload)
+}
+
+// DoRequestContext is the same as DoRequest, however, the request is bound
+// to the context, so that the request is cancelled when the context is.
+//
+// If the microservice responds 401 Unauthorized and any of the credentials
+// can be invalidated, the credentials are invalidated and the request is
+// sent once more with the fresh credentials.
+//
+// If the call fails and a fallback is set, either with WithFallback or
+// Service.Fallback for a GET or HEAD call, the response of the fallback is
+// returned instead.
+func (s *Service) DoRequestContext(ctx context.Context, method string, URL url.URL, query url.Values, headers http.Header, payload io.Reader) (*http.Response, dutil.Error) {
+	client := s.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+	// copy the default service query params to not alter them
+	qs := make(url.Values)
+	for key, values := range s.Values {
+		qs[key] = append([]string(nil), values...)
+	}
 	// set / override additional query params iff necessary
 	for key, values := range query {
 		for _, value := range values {
@@ -97,56 +221,139 @@ func (s *Service) DoRequest(method string, URL url.URL, query url.Values, header
 	// set the query params
 	URL.RawQuery = qs.Encode()
 
+	// read the payload only to sign the request or to be able to send the
+	// request more than once, otherwise the payload is streamed
+	var body []byte
+	if payload != nil && (s.Signer != nil || s.invalidates()) {
+		xb, err := io.ReadAll(payload)
+		if err != nil {
+			e := dutil.NewErr(500, "payload", []string{err.Error()})
+			return nil, e
+		}
+		body = xb
+		payload = bytes.NewReader(body)
+	}
+
+	res, e := s.send(ctx, client, method, URL, headers, payload, body)
+	if e == nil && res.StatusCode == http.StatusUnauthorized && s.invalidate() {
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+		if body != nil {
+			payload = bytes.NewReader(body)
+		}
+		res, e = s.send(ctx, client, method, URL, headers, payload, body)
+	}
+	if fb := s.fallback(ctx, method); fb != nil {
+		return s.withFallback(ctx, fb, method, URL, res, e)
+	}
+	if e != nil {
+		return nil, e
+	}
+	return res, nil
+}
+
+// send creates the request with the service headers and credentials and
+// sends the request. The body is the read payload iff the request is
+// signed.
+func (s *Service) send(ctx context.Context, client *http.Client, method string, URL url.URL, headers http.Header, payload io.Reader, body []byte) (*http.Response, dutil.Error) {
 	// create the request
-	req, _ := http.NewRequest(method, URL.String(), payload)
+	req, err := http.NewRequestWithContext(ctx, method, URL.String(), payload)
+	if err != nil {
+		e := dutil.NewErr(500, "request", []string{err.Error()})
+		return nil, e
+	}
 
 	// set the default service headers
-	req.Header = s.Header
+	req.Header = s.Header.Clone()
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	// set the current credentials
+	for key, provider := range s.Credentials {
+		v, err := provider.Credential(ctx)
+		if err != nil {
+			e := dutil.NewErr(500, "credential", []string{fmt.Sprintf("%s: %v", key, err)})
+			return nil, e
+		}
+		req.Header.Set(key, v)
+	}
 	// set / override additional headers iff necessary
 	for key, values := range headers {
 		for _, value := range values {
 			req.Header.Add(key, value)
 		}
 	}
+	// sign the request as the last step
+	if s.Signer != nil {
+		err = s.Signer.Sign(req, body)
+		if err != nil {
+			e := dutil.NewErr(500, "sign", []string{err.Error()})
+			return nil, e
+		}
+	}
 	// send the request
 	res, err := client.Do(req)
-	log.Printf("- %s-service -> [%s %s] <- %d", s.Name, req.Method, req.URL.String(), res.StatusCode)
+	target := req.URL.String()
+	if route := RouteTemplate(ctx); route != "" {
+		target = fmt.Sprintf("%s (%s)", target, route)
+	}
 	// if there was an error making the request not an error response
 	if err != nil {
-		e := dutil.NewErr(500, "request", []string{err.Error()})
+		s.logf("- %s-service -> [%s %s] <- %v", s.Name, req.Method, target, err)
+		key := "request"
+		if errors.Is(err, ErrCircuitOpen) {
+			key = "circuit"
+		}
+		e := dutil.NewErr(500, key, []string{err.Error()})
 		return nil, e
 	}
+	s.logf("- %s-service -> [%s %s] <- %d", s.Name, req.Method, target, res.StatusCode)
 	return res, nil
 }
 
-// HealthCheck is the health-check function which makes a request to the
-// microservice to check that the service is still up and running.
-// Simply return a true if a request is successful.
-func (s *Service) HealthCheck() (bool, dutil.Error) {
-	s.URL.Path = "/"
-
-	resp := struct {
-		Message string              `json:"message"`
-		Data    interface{}         `json:"data"`
-		Errors  map[string][]string `json:"errors"`
-	}{}
-
-	res, e := s.DoRequest("GET", s.URL, nil, nil, nil)
-	if e != nil {
-		return false, e
+// logf logs to the logger of the service or the standard logger.
+func (s *Service) logf(format string, v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+		return
 	}
-	_, e = Decode(res, &resp)
-	if e != nil {
-		return false, e
+	log.Printf(format, v...)
+}
+
+// invalidates reports whether any credential can be invalidated, in
+// which case a request may be sent more than once.
+func (s *Service) invalidates() bool {
+	for _, provider := range s.Credentials {
+		if _, ok := provider.(Invalidator); ok {
+			return true
+		}
 	}
+	return false
+}
 
-	// manage the response separately
-	if res.StatusCode != 200 {
-		e := &dutil.Err{
-			Status: res.StatusCode,
-			Errors: resp.Errors,
+// invalidate invalidates the credentials that can be invalidated and
+// reports whether any credential was invalidated.
+func (s *Service) invalidate() bool {
+	invalidated := false
+	for _, provider := range s.Credentials {
+		if i, ok := provider.(Invalidator); ok {
+			i.Invalidate()
+			invalidated = true
 		}
-		return false, e
+	}
+	return invalidated
+}
+
+// HealthCheck is the health-check function which makes a request to the
+// microservice to check that the service is still up and running.
+// Simply return a true if a request is successful.
+//
+// HealthCheck makes the Readiness probe, use Probe for the complete result
+// of the health check.
+func (s *Service) HealthCheck() (bool, dutil.Error) {
+	r := s.Readiness(context.Background())
+	if !r.Healthy() {
+		return false, r.Error
 	}
 	return true, nil
 }
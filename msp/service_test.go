@@ -202,6 +202,7 @@ func TestService_GetHome(t *testing.T) {
 					Status: 500,
 					Errors: map[string][]string{
 						"unmarshal": {"invalid character ']' after object key:value pair"},
+						"body":      {`{"message":"Welcome to the micro-service","data":{},"errors":{"internal_server_error":"server down for some reason"]}}`},
 					},
 				},
 			},
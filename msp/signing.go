@@ -0,0 +1,243 @@
+package msp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The headers of a request signed by an HMACSigner.
+const (
+	SignatureKeyHeader       = "X-Signature-Key"
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+	SignatureNonceHeader     = "X-Signature-Nonce"
+	SignatureHeader          = "X-Signature"
+)
+
+// HMACSigner signs requests with HMAC-SHA256 for service-to-service
+// authentication. The signature covers the method, path, sorted query,
+// body digest, timestamp and a nonce:
+//
+//	METHOD\nPATH\nQUERY\nSHA256(BODY)\nTIMESTAMP\nNONCE
+type HMACSigner struct {
+	// KeyID identifies the secret to the receiving service.
+	KeyID  string
+	Secret []byte
+}
+
+// Sign sets the signature headers of the request, body is the payload of
+// the request.
+func (h *HMACSigner) Sign(req *http.Request, body []byte) error {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	n := hex.EncodeToString(nonce)
+
+	req.Header.Set(SignatureKeyHeader, h.KeyID)
+	req.Header.Set(SignatureTimestampHeader, timestamp)
+	req.Header.Set(SignatureNonceHeader, n)
+	req.Header.Set(SignatureHeader, signature(h.Secret, req.Method, req.URL, body, timestamp, n))
+	return nil
+}
+
+// signature returns the hex encoded HMAC-SHA256 of the canonical request.
+func signature(secret []byte, method string, u *url.URL, body []byte, timestamp string, nonce string) string {
+	digest := sha256.Sum256(body)
+	canonical := strings.Join([]string{
+		strings.ToUpper(method),
+		u.EscapedPath(),
+		canonicalQuery(u.Query()),
+		hex.EncodeToString(digest[:]),
+		timestamp,
+		nonce,
+	}, "\n")
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(canonical))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// canonicalQuery encodes the query sorted by key and by value.
+func canonicalQuery(q url.Values) string {
+	sorted := make(url.Values, len(q))
+	for key, values := range q {
+		xs := append([]string(nil), values...)
+		sort.Strings(xs)
+		sorted[key] = xs
+	}
+	return sorted.Encode()
+}
+
+// NonceStore remembers the nonces of the requests that have been received
+// to reject replayed requests.
+type NonceStore interface {
+	// Seen records the nonce until it expires and reports whether the nonce
+	// has been seen before.
+	Seen(nonce string, expires time.Time) bool
+}
+
+// nonceSweepInterval is the minimum interval between the removals of the
+// expired nonces of a memoryNonces.
+const nonceSweepInterval = time.Minute
+
+// memoryNonces is an in-memory NonceStore.
+type memoryNonces struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	// sweep is the time of the next removal of the expired nonces.
+	sweep time.Time
+}
+
+// Seen records the nonce, the expired nonces are removed at most once per
+// nonceSweepInterval so that a request does not scan all the nonces.
+func (m *memoryNonces) Seen(nonce string, expires time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if now.After(m.sweep) {
+		for n, exp := range m.nonces {
+			if now.After(exp) {
+				delete(m.nonces, n)
+			}
+		}
+		m.sweep = now.Add(nonceSweepInterval)
+	}
+	if exp, ok := m.nonces[nonce]; ok && !now.After(exp) {
+		return true
+	}
+	m.nonces[nonce] = expires
+	return false
+}
+
+// HMACVerifier verifies the signatures of the requests received from
+// services that sign their requests with an HMACSigner.
+type HMACVerifier struct {
+	// Keys are the secrets keyed by their key ID.
+	Keys map[string][]byte
+	// MaxSkew is the maximum difference between the timestamp of a request
+	// and the current time, default 5 minutes.
+	MaxSkew time.Duration
+	// Nonces remembers the nonces to reject replayed requests, default an
+	// in-memory store.
+	Nonces NonceStore
+	// MaxBodySize is the maximum number of bytes read from a request body,
+	// a larger request is rejected before the signature is verified,
+	// default 10 MiB.
+	MaxBodySize int64
+}
+
+// errBodyTooLarge is the error of a request body larger than the
+// MaxBodySize of the HMACVerifier.
+var errBodyTooLarge = errors.New("request body too large")
+
+// NewHMACVerifier creates an HMACVerifier for the secrets keyed by their
+// key ID.
+func NewHMACVerifier(keys map[string][]byte) *HMACVerifier {
+	return &HMACVerifier{
+		Keys:        keys,
+		MaxSkew:     5 * time.Minute,
+		Nonces:      &memoryNonces{nonces: make(map[string]time.Time)},
+		MaxBodySize: 10 << 20,
+	}
+}
+
+// Verify verifies the signature of the request, the body of the request is
+// read and replaced so that it can still be read by the next handler.
+func (v *HMACVerifier) Verify(r *http.Request) error {
+	keyID := r.Header.Get(SignatureKeyHeader)
+	timestamp := r.Header.Get(SignatureTimestampHeader)
+	nonce := r.Header.Get(SignatureNonceHeader)
+	sig := r.Header.Get(SignatureHeader)
+	if keyID == "" || timestamp == "" || nonce == "" || sig == "" {
+		return errors.New("missing signature headers")
+	}
+	secret, ok := v.Keys[keyID]
+	if !ok {
+		return fmt.Errorf("unknown key %s", keyID)
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	skew := v.MaxSkew
+	if skew <= 0 {
+		skew = 5 * time.Minute
+	}
+	t := time.Unix(unix, 0)
+	if d := time.Since(t); d > skew || d < -skew {
+		return errors.New("timestamp outside of the allowed clock skew")
+	}
+
+	var body []byte
+	if r.Body != nil {
+		max := v.MaxBodySize
+		if max <= 0 {
+			max = 10 << 20
+		}
+		// read one more byte to know whether the body exceeds the maximum
+		body, err = io.ReadAll(io.LimitReader(r.Body, max+1))
+		if err != nil {
+			return err
+		}
+		_ = r.Body.Close()
+		if int64(len(body)) > max {
+			return fmt.Errorf("%w, the maximum size is %d bytes", errBodyTooLarge, max)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	E := signature(secret, r.Method, r.URL, body, timestamp, nonce)
+	if !hmac.Equal([]byte(E), []byte(sig)) {
+		return errors.New("invalid signature")
+	}
+
+	// only record the nonce of a valid request
+	if v.Nonces != nil && v.Nonces.Seen(keyID+":"+nonce, t.Add(skew)) {
+		return errors.New("replayed request")
+	}
+	return nil
+}
+
+// Middleware rejects the requests that do not have a valid signature with
+// 401 Unauthorized, or a body larger than the MaxBodySize with 413 Request
+// Entity Too Large, before they reach the next handler.
+func (v *HMACVerifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := v.Verify(r)
+		if err != nil {
+			status, message := http.StatusUnauthorized, "unauthorized"
+			if errors.Is(err, errBodyTooLarge) {
+				status, message = http.StatusRequestEntityTooLarge, "request entity too large"
+			}
+			resp := struct {
+				Message string              `json:"message"`
+				Data    interface{}         `json:"data"`
+				Errors  map[string][]string `json:"errors"`
+			}{
+				Message: message,
+				Data:    map[string]interface{}{},
+				Errors:  map[string][]string{"signature": {err.Error()}},
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(resp)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,213 @@
+package msp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHMACSigner(t *testing.T) {
+	v := NewHMACVerifier(map[string][]byte{"gateway": []byte("secret")})
+	var body string
+	ts := httptest.NewServer(v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		xb, _ := io.ReadAll(r.Body)
+		body = string(xb)
+		w.WriteHeader(200)
+	})))
+	defer ts.Close()
+
+	s := NewService(Config{
+		Name:   "micro",
+		Signer: &HMACSigner{KeyID: "gateway", Secret: []byte("secret")},
+	})
+	u, _ := url.Parse(ts.URL)
+	s.SetURL(u.Scheme, u.Host)
+	s.URL.Path = "/users/a b"
+
+	q := url.Values{"b": {"2", "1"}, "a": {"x"}}
+	res, e := s.DoRequest("POST", s.URL, q, nil, strings.NewReader(`{"name":"james"}`))
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if res.StatusCode != 200 {
+		xb, _ := io.ReadAll(res.Body)
+		t.Fatalf("expected %d got %d: %s", 200, res.StatusCode, xb)
+	}
+	if body != `{"name":"james"}` {
+		t.Errorf("expected '%v' got '%v'", `{"name":"james"}`, body)
+	}
+}
+
+func TestHMACVerifier_Verify(t *testing.T) {
+	signer := &HMACSigner{KeyID: "gateway", Secret: []byte("secret")}
+	sign := func(body string) *http.Request {
+		r := httptest.NewRequest("PUT", "/users/1?z=1&a=2", strings.NewReader(body))
+		err := signer.Sign(r, []byte(body))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return r
+	}
+
+	tt := []struct {
+		name string
+		req  func() *http.Request
+		E    string
+	}{
+		{
+			name: "valid",
+			req:  func() *http.Request { return sign(`{}`) },
+		},
+		{
+			name: "missing headers",
+			req:  func() *http.Request { return httptest.NewRequest("GET", "/", nil) },
+			E:    "missing signature headers",
+		},
+		{
+			name: "unknown key",
+			req: func() *http.Request {
+				r := sign(`{}`)
+				r.Header.Set(SignatureKeyHeader, "other")
+				return r
+			},
+			E: "unknown key other",
+		},
+		{
+			name: "tampered body",
+			req: func() *http.Request {
+				r := sign(`{}`)
+				r.Body = io.NopCloser(strings.NewReader(`{"admin":true}`))
+				return r
+			},
+			E: "invalid signature",
+		},
+		{
+			name: "tampered query",
+			req: func() *http.Request {
+				r := sign(`{}`)
+				r.URL.RawQuery = "z=2&a=2"
+				return r
+			},
+			E: "invalid signature",
+		},
+		{
+			name: "clock skew",
+			req: func() *http.Request {
+				r := sign(`{}`)
+				old := time.Now().Add(-10 * time.Minute).Unix()
+				r.Header.Set(SignatureTimestampHeader, strconv.FormatInt(old, 10))
+				return r
+			},
+			E: "timestamp outside of the allowed clock skew",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			v := NewHMACVerifier(map[string][]byte{"gateway": []byte("secret")})
+			err := v.Verify(tc.req())
+			if tc.E == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.E {
+				t.Errorf("expected '%v' got '%v'", tc.E, err)
+			}
+		})
+	}
+}
+
+func TestHMACVerifier_replay(t *testing.T) {
+	v := NewHMACVerifier(map[string][]byte{"gateway": []byte("secret")})
+	signer := &HMACSigner{KeyID: "gateway", Secret: []byte("secret")}
+	r := httptest.NewRequest("GET", "/", nil)
+	_ = signer.Sign(r, nil)
+
+	h := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	}))
+	for i, E := range []int{204, 401} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r.Clone(r.Context()))
+		if rec.Code != E {
+			t.Errorf("%d: expected %d got %d", i, E, rec.Code)
+		}
+		if E == 401 {
+			EBody := `{"message":"unauthorized","data":{},"errors":{"signature":["replayed request"]}}` + "\n"
+			if rec.Body.String() != EBody {
+				t.Errorf("expected '%v' got '%v'", EBody, rec.Body.String())
+			}
+		}
+	}
+}
+
+func TestHMACVerifier_MaxBodySize(t *testing.T) {
+	v := NewHMACVerifier(map[string][]byte{"gateway": []byte("secret")})
+	v.MaxBodySize = 16
+	signer := &HMACSigner{KeyID: "gateway", Secret: []byte("secret")}
+	h := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		xb, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(xb)
+	}))
+
+	tt := []struct {
+		name string
+		body string
+		E    int
+	}{
+		{name: "maximum size", body: `{"name":"james"}`, E: 200},
+		{name: "too large", body: `{"name":"jamesy"}`, E: 413},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tc.body))
+			_ = signer.Sign(r, []byte(tc.body))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tc.E {
+				t.Errorf("expected %d got %d", tc.E, rec.Code)
+			}
+			if tc.E == 200 && rec.Body.String() != tc.body {
+				t.Errorf("expected '%v' got '%v'", tc.body, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestMemoryNonces_Seen(t *testing.T) {
+	m := &memoryNonces{nonces: make(map[string]time.Time)}
+	now := time.Now()
+	if m.Seen("a", now.Add(time.Minute)) {
+		t.Errorf("expected 'a' not to be seen")
+	}
+	if !m.Seen("a", now.Add(time.Minute)) {
+		t.Errorf("expected 'a' to be seen")
+	}
+	// an expired nonce is not seen, even before it is removed
+	m.nonces["b"] = now.Add(-time.Second)
+	if m.Seen("b", now.Add(time.Minute)) {
+		t.Errorf("expected 'b' not to be seen")
+	}
+
+	// the expired nonces are only removed once the sweep is due
+	m.nonces["c"] = now.Add(-time.Second)
+	_ = m.Seen("d", now.Add(time.Minute))
+	if _, ok := m.nonces["c"]; !ok {
+		t.Errorf("expected 'c' to be kept until the sweep")
+	}
+	m.sweep = now.Add(-time.Second)
+	_ = m.Seen("e", now.Add(time.Minute))
+	if _, ok := m.nonces["c"]; ok {
+		t.Errorf("expected 'c' to be removed")
+	}
+	if len(m.nonces) != 4 {
+		t.Errorf("expected %d got %d", 4, len(m.nonces))
+	}
+}
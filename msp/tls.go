@@ -0,0 +1,246 @@
+package msp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSConfig is the TLS configuration used to connect to a microservice. The
+// CA bundle and the client certificate and key are either read from files
+// or from environmental variables containing the PEM. Files are reloaded
+// when they change, new connections use the reloaded certificates.
+type TLSConfig struct {
+	// CAFile is a PEM bundle of the CAs that are trusted to verify the
+	// certificate of the microservice.
+	CAFile string
+	// CAPEMEnv is the environmental variable containing a PEM bundle of the
+	// CAs that are trusted.
+	CAPEMEnv string
+	// CertFile and KeyFile are the client certificate and key presented
+	// to the microservice for mutual TLS.
+	CertFile string
+	KeyFile  string
+	// CertPEMEnv and KeyPEMEnv are the environmental variables containing
+	// the PEM of the client certificate and key.
+	CertPEMEnv string
+	KeyPEMEnv  string
+	// MinVersion is the minimum TLS version, default TLS 1.2.
+	MinVersion uint16
+	// ServerName overrides the name used to verify the certificate of the
+	// microservice.
+	ServerName string
+}
+
+// Config creates the tls.Config. When a CA is configured the certificate
+// of the microservice is only verified against the configured CAs.
+//
+// WARNING: when a CA is configured the returned config sets
+// InsecureSkipVerify and verifies the certificate in VerifyConnection
+// instead, so that the CAs are reloaded. A copy of the config without its
+// VerifyConnection does not verify the certificate at all. The certificate
+// is verified for the ServerName, or the server name of the connection,
+// a connection to an IP address without a ServerName is rejected. Use
+// Transport to verify the certificate for the dialled host.
+func (c TLSConfig) Config() *tls.Config {
+	return c.config(&tlsReloader{config: c}, "")
+}
+
+// config creates the tls.Config with the reloader, the certificate of the
+// microservice is verified for the ServerName or else the host.
+func (c TLSConfig) config(r *tlsReloader, host string) *tls.Config {
+	tc := &tls.Config{
+		MinVersion: c.MinVersion,
+		ServerName: c.ServerName,
+	}
+	if tc.MinVersion == 0 {
+		tc.MinVersion = tls.VersionTLS12
+	}
+	if c.CertFile != "" || c.CertPEMEnv != "" {
+		tc.GetClientCertificate = r.clientCertificate
+	}
+	if c.CAFile != "" || c.CAPEMEnv != "" {
+		name := c.ServerName
+		if name == "" {
+			name = host
+		}
+		// the default verification is replaced to verify against the
+		// reloaded CAs
+		tc.InsecureSkipVerify = true
+		tc.VerifyConnection = func(cs tls.ConnectionState) error {
+			return r.verifyConnection(cs, name)
+		}
+	}
+	return tc
+}
+
+// Transport creates an http.Transport with the TLS configuration. The
+// certificate of the microservice is verified for the ServerName or else
+// the host that is dialled, which may be an IP address.
+func (c TLSConfig) Transport() *http.Transport {
+	r := &tlsReloader{config: c}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = c.config(r, "")
+	if c.CAFile == "" && c.CAPEMEnv == "" {
+		return t
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		tc := c.config(r, host)
+		// an IP address is not sent as the server name
+		if tc.ServerName == "" && net.ParseIP(host) == nil {
+			tc.ServerName = host
+		}
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, tc)
+		err = tlsConn.HandshakeContext(ctx)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+	return t
+}
+
+// tlsReloader loads the certificates of a TLSConfig and reloads them when
+// the files change.
+type tlsReloader struct {
+	config TLSConfig
+
+	mu         sync.Mutex
+	pool       *x509.CertPool
+	poolStamp  fileStamp
+	cert       *tls.Certificate
+	certStamps [2]fileStamp
+}
+
+// clientCertificate returns the client certificate presented to the
+// microservice.
+func (r *tlsReloader) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.config.CertPEMEnv != "" {
+		if r.cert == nil {
+			cert, err := tls.X509KeyPair([]byte(os.Getenv(r.config.CertPEMEnv)), []byte(os.Getenv(r.config.KeyPEMEnv)))
+			if err != nil {
+				return nil, fmt.Errorf("client certificate from %s: %w", r.config.CertPEMEnv, err)
+			}
+			r.cert = &cert
+		}
+		return r.cert, nil
+	}
+
+	certStamp, err := stat(r.config.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	keyStamp, err := stat(r.config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if r.cert == nil || certStamp != r.certStamps[0] || keyStamp != r.certStamps[1] {
+		cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		r.cert = &cert
+		r.certStamps = [2]fileStamp{certStamp, keyStamp}
+	}
+	return r.cert, nil
+}
+
+// caPool returns the pool of the CAs that are trusted.
+func (r *tlsReloader) caPool() (*x509.CertPool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.config.CAPEMEnv != "" {
+		if r.pool == nil {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM([]byte(os.Getenv(r.config.CAPEMEnv))) {
+				return nil, fmt.Errorf("no CA certificates in %s", r.config.CAPEMEnv)
+			}
+			r.pool = pool
+		}
+		return r.pool, nil
+	}
+
+	stamp, err := stat(r.config.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	if r.pool == nil || stamp != r.poolStamp {
+		xb, err := os.ReadFile(r.config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(xb) {
+			return nil, fmt.Errorf("no CA certificates in %s", r.config.CAFile)
+		}
+		r.pool = pool
+		r.poolStamp = stamp
+	}
+	return r.pool, nil
+}
+
+// verifyConnection verifies the certificate chain of the microservice
+// against the CAs that are trusted and the certificate for the name, or
+// else the server name of the connection.
+func (r *tlsReloader) verifyConnection(cs tls.ConnectionState, name string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("tls: no certificate from the microservice")
+	}
+	if name == "" {
+		name = cs.ServerName
+	}
+	// an empty name skips the verification of the name of the certificate
+	if name == "" {
+		return errors.New("tls: no server name to verify the certificate of the microservice")
+	}
+	pool, err := r.caPool()
+	if err != nil {
+		return err
+	}
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       name,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err = cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// fileStamp is the modification time and size of a file, a file is
+// reloaded when either changes, so that a change within the granularity of
+// the modification time is not missed.
+type fileStamp struct {
+	mod  int64
+	size int64
+}
+
+// stat returns the stamp of the file.
+func stat(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{mod: info.ModTime().UnixNano(), size: info.Size()}, nil
+}
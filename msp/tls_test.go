@@ -0,0 +1,275 @@
+package msp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/johannesscr/micro/microtest"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is a certificate authority used to issue client certificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newTestCA creates a self-signed certificate authority.
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue issues a client certificate and returns the PEM of the certificate
+// and the key.
+func (ca *testCA) issue(t *testing.T, cn string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	xb, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: xb})
+}
+
+// issueServer issues a server certificate for the DNS names and IP
+// addresses.
+func (ca *testCA) issueServer(t *testing.T, names []string, ips []net.IP) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     names,
+		IPAddresses:  ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLSConfig_mutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	dir := t.TempDir()
+	write := func(name string, xb []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, xb, 0600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return path
+	}
+	cert, key := ca.issue(t, "client-1")
+	certFile := write("client.crt", cert)
+	keyFile := write("client.key", key)
+
+	s := NewService(Config{Name: "micro"})
+	ms := microtest.MockServerTLS(s, microtest.TLSOptions{ClientCAs: pool})
+	defer ms.Server.Close()
+	caFile := write("ca.crt", ms.CertificatePEM())
+
+	s = NewService(Config{
+		Name: "micro",
+		TLS: &TLSConfig{
+			CAFile:   caFile,
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		},
+	})
+	s.SetURL("https", ms.Server.Listener.Addr().String())
+
+	ex := &microtest.Exchange{Response: microtest.Response{Status: 200}}
+	ms.Append(ex)
+	_, e := s.DoRequest("GET", s.URL, nil, nil, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if cn := ex.Request.TLS.PeerCertificates[0].Subject.CommonName; cn != "client-1" {
+		t.Errorf("expected '%v' got '%v'", "client-1", cn)
+	}
+
+	// rotate the client certificate within the granularity of the
+	// modification time, new connections use the new certificate
+	mods := make(map[string]time.Time)
+	for _, path := range []string{certFile, keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		mods[path] = info.ModTime()
+	}
+	cert, key = ca.issue(t, "client-rotated")
+	write("client.crt", cert)
+	write("client.key", key)
+	for path, mod := range mods {
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	s.Client.CloseIdleConnections()
+
+	ex = &microtest.Exchange{Response: microtest.Response{Status: 200}}
+	ms.Append(ex)
+	_, e = s.DoRequest("GET", s.URL, nil, nil, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if cn := ex.Request.TLS.PeerCertificates[0].Subject.CommonName; cn != "client-rotated" {
+		t.Errorf("expected '%v' got '%v'", "client-rotated", cn)
+	}
+}
+
+func TestTLSConfig_env(t *testing.T) {
+	ca := newTestCA(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	cert, key := ca.issue(t, "client-env")
+
+	s := NewService(Config{Name: "micro"})
+	ms := microtest.MockServerTLS(s, microtest.TLSOptions{ClientCAs: pool})
+	defer ms.Server.Close()
+
+	t.Setenv("MICRO_TEST_CA_PEM", string(ms.CertificatePEM()))
+	t.Setenv("MICRO_TEST_CERT_PEM", string(cert))
+	t.Setenv("MICRO_TEST_KEY_PEM", string(key))
+
+	URL := s.URL
+	s = NewService(Config{
+		Name: "micro",
+		TLS: &TLSConfig{
+			CAPEMEnv:   "MICRO_TEST_CA_PEM",
+			CertPEMEnv: "MICRO_TEST_CERT_PEM",
+			KeyPEMEnv:  "MICRO_TEST_KEY_PEM",
+		},
+	})
+	s.URL = URL
+
+	ex := &microtest.Exchange{Response: microtest.Response{Status: 200}}
+	ms.Append(ex)
+	_, e := s.DoRequest("GET", s.URL, nil, nil, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if cn := ex.Request.TLS.PeerCertificates[0].Subject.CommonName; cn != "client-env" {
+		t.Errorf("expected '%v' got '%v'", "client-env", cn)
+	}
+}
+
+func TestTLSConfig_untrusted(t *testing.T) {
+	s := NewService(Config{Name: "micro"})
+	ms := microtest.MockServerTLS(s, microtest.TLSOptions{})
+	defer ms.Server.Close()
+
+	// trust a different CA than the certificate of the mock server
+	ca := newTestCA(t)
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(caFile, ca.pem, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	URL := s.URL
+	s = NewService(Config{Name: "micro", TLS: &TLSConfig{CAFile: caFile}})
+	s.URL = URL
+
+	_, e := s.DoRequest("GET", s.URL, nil, nil, nil)
+	if e == nil {
+		t.Errorf("expected an error got nil")
+	}
+}
+
+func TestTLSConfig_serverName(t *testing.T) {
+	ca := newTestCA(t)
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(caFile, ca.pem, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	localhost := []net.IP{net.ParseIP("127.0.0.1")}
+
+	tt := []struct {
+		name       string
+		names      []string
+		ips        []net.IP
+		serverName string
+		E          bool
+	}{
+		{name: "IP address", ips: localhost},
+		{name: "wrong name at an IP address", names: []string{"evil.example.com"}, E: true},
+		{name: "server name", names: []string{"micro.example.com"}, serverName: "micro.example.com"},
+		{name: "wrong server name", names: []string{"evil.example.com"}, serverName: "micro.example.com", E: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			ts.TLS = &tls.Config{Certificates: []tls.Certificate{ca.issueServer(t, tc.names, tc.ips)}}
+			ts.StartTLS()
+			defer ts.Close()
+
+			s := NewService(Config{Name: "micro", TLS: &TLSConfig{CAFile: caFile, ServerName: tc.serverName}})
+			s.SetURL("https", ts.Listener.Addr().String())
+			_, e := s.DoRequest("GET", s.URL, nil, nil, nil)
+			if tc.E && e == nil {
+				t.Errorf("expected an error got nil")
+			}
+			if !tc.E && e != nil {
+				t.Errorf("unexpected error: %v", e)
+			}
+		})
+	}
+}